@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"boot.mossad.http/internal/certgen"
+)
+
+// Usage:
+//
+//	go run ./cmd/certgen -hosts localhost,127.0.0.1,::1
+//	go run ./cmd/certgen -name client -hosts spiffe://dev/ns/ops/sa/admin
+//
+// The CA is created on the first run (ca.pem / ca-key.pem) and reused afterwards,
+// so every leaf is signed by the same local CA that can be trusted once.
+func main() {
+	outDir := flag.String("out", "certs", "directory where the PEM files are written")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated DNS names, IPs or URIs for the certificate")
+	name := flag.String("name", "server", "file name prefix of the leaf certificate (<name>.pem, <name>-key.pem)")
+	days := flag.Int("days", 365, "validity of the leaf certificate in days")
+	caDays := flag.Int("ca-days", 3650, "validity of a newly created CA in days")
+	flag.Parse()
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Error creating %s: %v", *outDir, err)
+	}
+
+	caCert := filepath.Join(*outDir, "ca.pem")
+	caKey := filepath.Join(*outDir, "ca-key.pem")
+
+	ca, err := certgen.LoadCA(caCert, caKey)
+	if errors.Is(err, os.ErrNotExist) {
+		ca, err = certgen.NewCA("boot.mossad.http local CA", time.Duration(*caDays)*24*time.Hour)
+		if err != nil {
+			log.Fatalf("Error creating CA: %v", err)
+		}
+		if err := certgen.WriteFiles(caCert, ca.CertPEM, caKey, ca.KeyPEM); err != nil {
+			log.Fatalf("Error writing CA: %v", err)
+		}
+		log.Printf("Created CA %s (add it to your trust store)", caCert)
+	} else if err != nil {
+		log.Fatalf("Error loading CA: %v", err)
+	}
+
+	certPEM, keyPEM, err := ca.Issue(certgen.SplitHosts(*hosts), time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		log.Fatalf("Error issuing certificate: %v", err)
+	}
+
+	certFile := filepath.Join(*outDir, *name+".pem")
+	keyFile := filepath.Join(*outDir, *name+"-key.pem")
+	if err := certgen.WriteFiles(certFile, certPEM, keyFile, keyPEM); err != nil {
+		log.Fatalf("Error writing certificate: %v", err)
+	}
+	log.Printf("Wrote %s and %s for %s", certFile, keyFile, *hosts)
+}
@@ -1,34 +1,200 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"boot.mossad.http/internal/certgen"
+	"boot.mossad.http/internal/fileserver"
+	"boot.mossad.http/internal/metrics"
+	"boot.mossad.http/internal/middleware"
 	"boot.mossad.http/internal/request"
 	"boot.mossad.http/internal/response"
 	"boot.mossad.http/internal/server"
+	"boot.mossad.http/internal/tracing"
 )
 
 const port = 42069
 
+// How long in-flight requests get to finish on shutdown or upgrade.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	server, err := server.Serve(port, handler)
-	if err != nil {
+	useTLS := flag.Bool("tls", false, "serve HTTPS, with an ephemeral self-signed certificate unless -cert/-key are given")
+	certFile := flag.String("cert", "", "PEM certificate file (see cmd/certgen)")
+	keyFile := flag.String("key", "", "PEM private key file")
+	clientCA := flag.String("client-ca", "", "PEM CA bundle, verifies client certificates when they are presented (mTLS)")
+	unixSocket := flag.String("unix", "", "listen on this Unix domain socket path instead of TCP (e.g. behind nginx)")
+	systemd := flag.Bool("systemd", false, "use the listening socket passed by systemd socket activation")
+	reusePort := flag.Int("reuseport", 0, "open N SO_REUSEPORT listeners, each with its own accept loop")
+	maxConns := flag.Int("max-conns", 0, "maximum concurrent connections, the extra ones get a 503 (0 = unlimited)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "maximum concurrent connections per remote IP (0 = unlimited)")
+	traceFile := flag.String("trace-file", "", "append a JSON line per request span to this file")
+	root := flag.String("root", "", "serve the static files of this directory instead of the demo pages")
+	listing := flag.Bool("listing", false, "with -root, list the directories without an index.html")
+	flag.Parse()
+
+	cfg := server.Config{
+		Addr:          fmt.Sprintf(":%d", port),
+		ReusePort:     *reusePort,
+		MaxConns:      *maxConns,
+		ShedLoad:      true,
+		MaxConnsPerIP: *maxConnsPerIP,
+		AccessLog:     log.New(os.Stdout, "", log.LstdFlags),
+	}
+
+	// Everything is scraped from /metrics.
+	reg := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTPMetrics(reg)
+	// The demo pages, the files of -root all count as "other".
+	httpMetrics.Route = metrics.RouteByPaths("/", "/400", "/500", "/metrics")
+	cfg.ConnState = httpMetrics.ConnState
+	cfg.ErrorHandler = httpMetrics.ErrorHandler(nil)
+	if *useTLS || *certFile != "" {
+		tlsConfig, err := loadTLSConfig(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS config: %v", err)
+		}
+		if *clientCA != "" {
+			if err := server.WithClientAuth(tlsConfig, *clientCA, false); err != nil {
+				log.Fatalf("Error loading client CA: %v", err)
+			}
+		}
+		cfg.TLSConfig = tlsConfig
+	}
+
+	if *unixSocket != "" {
+		cfg.Network = "unix"
+		cfg.Addr = *unixSocket
+		cfg.UnixSocket = server.UnixSocketOptions{Mode: 0660, RemoveStale: true}
+	}
+
+	// Trace ids are always propagated, spans are only written with -trace-file.
+	traceCfg := tracing.Config{}
+	if *traceFile != "" {
+		exporter, err := tracing.NewFileExporter(*traceFile)
+		if err != nil {
+			log.Fatalf("Error opening trace file: %v", err)
+		}
+		defer exporter.Close()
+		traceCfg.Exporter = exporter
+	}
+
+	// The demo pages are small, let the ETag middleware buffer and tag them.
+	// The file server streams and tags the files itself.
+	site := middleware.ETag(middleware.ETagConfig{})(handler)
+	if *root != "" {
+		fsys, err := fileserver.Dir(*root)
+		if err != nil {
+			log.Fatalf("Error opening %s: %v", *root, err)
+		}
+		site = fileserver.New(fsys, fileserver.Options{Listing: *listing})
+	}
+	// Outside ETag, so the tag is computed on the plain body and weakened once compressed.
+	site = middleware.Compress(middleware.CompressConfig{})(site)
+
+	srv := server.New(cfg, server.Chain(routes(metrics.Handler(reg), site),
+		middleware.RequestID(middleware.RequestIDConfig{TrustIncoming: true}),
+		tracing.Middleware(traceCfg),
+		httpMetrics.Middleware(),
+	))
+	if err := start(srv, *systemd); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", srv.Addr())
+
+	// Tell the parent we took over, when we were started by a hot upgrade.
+	if err := server.NotifyUpgradeReady(); err != nil {
+		log.Printf("Error notifying the parent process: %v", err)
+	}
 
 	// Common pattern in golang for gracefully shutting down a server
 	// The program won't exit the main until a signal from the os is send like ctrl+c
+	// SIGHUP / SIGUSR2 start a new binary on the same socket, then this one drains and exits.
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP || sig == syscall.SIGUSR2 {
+			child, err := srv.Reexec(10 * time.Second)
+			if err != nil {
+				log.Printf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			log.Printf("Upgraded to pid %d, draining connections", child.Pid)
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down, closing %d remaining connections: %v", len(srv.Connections()), err)
+		srv.CloseConnections()
+	}
 	log.Println("Server gracefully stopped")
 }
 
+// start picks the listener: inherited from a parent on upgrade, passed by systemd, or our own.
+func start(srv *server.Server, systemd bool) error {
+	listeners, err := server.InheritedListeners()
+	if err == nil {
+		return srv.ServeListeners(listeners...)
+	}
+	if err != server.ErrNoInheritedListeners {
+		return err
+	}
+
+	if systemd {
+		listeners, err := server.SystemdListeners()
+		if err != nil {
+			return err
+		}
+		return srv.ServeListeners(listeners...)
+	}
+
+	return srv.ListenAndServe()
+}
+
+// loadTLSConfig uses the given certificate (reloaded from disk when it changes),
+// or generates a throwaway one for localhost when none is given.
+func loadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile != "" {
+		certs := server.NewCertStore()
+		if err := certs.Add(certFile, keyFile); err != nil {
+			return nil, err
+		}
+		return certs.TLSConfig(), nil
+	}
+
+	cert, err := certgen.SelfSigned([]string{"localhost", "127.0.0.1", "::1"}, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Using an ephemeral self-signed certificate, browsers will warn about it")
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// routes serves /metrics, everything else goes to the site.
+func routes(metricsHandler, site server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if metrics.RoutePath(req) == "/metrics" {
+			metricsHandler(w, req)
+			return
+		}
+		site(w, req)
+	}
+}
 
 const html200 = `<html>
   <head>
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A tiny load generator, one fresh TCP connection per request (our server closes after every response),
+// which is exactly the accept-heavy pattern SO_REUSEPORT is meant for.
+//
+//	go run ./cmd/httpserver -reuseport 8
+//	go run ./cmd/loadgen -c 256 -d 10s
+func main() {
+	addr := flag.String("addr", "127.0.0.1:42069", "server address")
+	path := flag.String("path", "/", "request target")
+	concurrency := flag.Int("c", 64, "number of concurrent clients")
+	duration := flag.Duration("d", 5*time.Second, "test duration")
+	flag.Parse()
+
+	raw := []byte(fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", *path, *addr))
+	deadline := time.Now().Add(*duration)
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	errors := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localErrors := 0
+			for time.Now().Before(deadline) {
+				start := time.Now()
+				if err := doRequest(*addr, raw); err != nil {
+					localErrors++
+					continue
+				}
+				local = append(local, time.Since(start))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, local...)
+			errors += localErrors
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(latencies) == 0 {
+		log.Fatalf("No successful requests, %d errors", errors)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("requests: %d (%.0f req/s), errors: %d\n", len(latencies), float64(len(latencies))/duration.Seconds(), errors)
+	fmt.Printf("latency p50: %v, p99: %v, max: %v\n",
+		latencies[len(latencies)/2], latencies[len(latencies)*99/100], latencies[len(latencies)-1])
+}
+
+func doRequest(addr string, raw []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(raw); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
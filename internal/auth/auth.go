@@ -0,0 +1,152 @@
+// Package auth authenticates requests from the Authorization header (Basic, Bearer and Digest)
+// and keeps who it was on the request context.
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// Principal is who made the request.
+type Principal struct {
+	Name   string // the user name, or the name given to a token
+	Scheme string // "Basic", "Bearer", ...
+}
+
+type principalKey struct{}
+
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext is the authenticated principal, nil when there is none.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// FromRequest is PrincipalFromContext(req.Context()).
+func FromRequest(req *request.Request) *Principal {
+	return PrincipalFromContext(req.Context())
+}
+
+// BasicVerifier checks a user name and password, see Htpasswd.
+type BasicVerifier func(user, password string) bool
+
+// TokenVerifier checks a bearer token and names its owner, see StaticTokens.
+type TokenVerifier func(token string) (name string, ok bool)
+
+// Config of the auth middleware, at least one of Basic and Bearer is needed.
+type Config struct {
+	// Realm names the protection space in the challenges, "restricted" when empty.
+	Realm string
+	// Basic enables Authorization: Basic.
+	Basic BasicVerifier
+	// Bearer enables Authorization: Bearer.
+	Bearer TokenVerifier
+}
+
+// Middleware lets through the requests with valid credentials, the principal in their context.
+// Anything else gets a 401 with a WWW-Authenticate challenge per enabled scheme, so browsers
+// show their login prompt for Basic. Panics without any scheme, a 401 must carry a challenge.
+func Middleware(cfg Config) server.Middleware {
+	if cfg.Basic == nil && cfg.Bearer == nil {
+		panic("auth: Config needs Basic or Bearer")
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "restricted"
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			header, _ := req.Headers.Get([]byte("Authorization"))
+			scheme, _, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+			var principal *Principal
+			errorCode := ""
+			switch {
+			case strings.EqualFold(scheme, "Basic") && cfg.Basic != nil:
+				if user, password, ok := ParseBasic(header); ok && cfg.Basic(user, password) {
+					principal = &Principal{Name: user, Scheme: "Basic"}
+				}
+			case strings.EqualFold(scheme, "Bearer") && cfg.Bearer != nil:
+				token, ok := ParseBearer(header)
+				if !ok {
+					errorCode = "invalid_request"
+					break
+				}
+				errorCode = "invalid_token"
+				if name, ok := cfg.Bearer(token); ok {
+					principal = &Principal{Name: name, Scheme: "Bearer"}
+				}
+			}
+
+			if principal == nil {
+				Challenge(w, cfg, errorCode)
+				return
+			}
+			next(w, req.WithContext(ContextWithPrincipal(req.Context(), principal)))
+		}
+	}
+}
+
+// Challenge answers 401 with the challenges of cfg. errorCode is the RFC 6750 error of the
+// Bearer challenge ("invalid_token", ...), empty when no token was sent.
+func Challenge(w *response.Writer, cfg Config, errorCode string) {
+	var challenges []string
+	if cfg.Basic != nil {
+		challenges = append(challenges, `Basic realm=`+headers.QuoteString(cfg.Realm)+`, charset="UTF-8"`)
+	}
+	if cfg.Bearer != nil {
+		bearer := `Bearer realm=` + headers.QuoteString(cfg.Realm)
+		if errorCode != "" {
+			bearer += `, error="` + errorCode + `"`
+		}
+		challenges = append(challenges, bearer)
+	}
+	// Several challenges in one field are fine, they are a comma separated list (RFC 9110 section 11.6.1).
+	w.Header().Set("WWW-Authenticate", strings.Join(challenges, ", "))
+	response.Error(w, response.StatusUnauthorized, "unauthorized\n")
+}
+
+// ParseBasic reads "Basic base64(user:password)". The password may contain ":", the user may not.
+func ParseBasic(header string) (user, password string, ok bool) {
+	scheme, credentials, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// ParseBearer reads "Bearer <token>", the token being token68 (RFC 6750 section 2.1).
+func ParseBearer(header string) (string, bool) {
+	scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", false
+	}
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~+/", c) >= 0) {
+			// "=" only as padding at the end
+			if strings.Trim(token[i:], "=") != "" {
+				return "", false
+			}
+			break
+		}
+	}
+	return token, true
+}
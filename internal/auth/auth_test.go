@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, handler func(w *response.Writer, req *request.Request), raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handler(w, req)
+	require.NoError(t, w.Close())
+	return out.String()
+}
+
+func whoami(w *response.Writer, req *request.Request) {
+	p := FromRequest(req)
+	w.Write([]byte(p.Scheme + ":" + p.Name))
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func get(authorization string) string {
+	raw := "GET /admin HTTP/1.1\r\n"
+	if authorization != "" {
+		raw += "Authorization: " + authorization + "\r\n"
+	}
+	return raw + "\r\n"
+}
+
+func TestHtpasswd(t *testing.T) {
+	users, err := ParseHtpasswd(strings.NewReader("# admins\n\nada:" + HashPassword("s3cret:with colon") + "\nbob:" + HashPassword("hunter2") + "\n"))
+	require.NoError(t, err)
+
+	assert.True(t, users.Verify("ada", "s3cret:with colon"))
+	assert.True(t, users.Verify("bob", "hunter2"))
+	assert.False(t, users.Verify("ada", "hunter2"))
+	assert.False(t, users.Verify("eve", "hunter2"))
+	assert.False(t, users.Verify("eve", ""))
+
+	// Test: Other hashes are refused up front
+	_, err = ParseHtpasswd(strings.NewReader("ada:$2y$10$abcdefghijklmnopqrstuv\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedHash)
+	_, err = ParseHtpasswd(strings.NewReader("no colon here\n"))
+	assert.Error(t, err)
+}
+
+func TestParseCredentials(t *testing.T) {
+	user, password, ok := ParseBasic(basic("ada", "a:b"))
+	assert.True(t, ok)
+	assert.Equal(t, "ada", user)
+	assert.Equal(t, "a:b", password)
+	_, _, ok = ParseBasic("Basic !!!")
+	assert.False(t, ok)
+	_, _, ok = ParseBasic("basic " + base64.StdEncoding.EncodeToString([]byte("nocolon")))
+	assert.False(t, ok)
+
+	token, ok := ParseBearer("bearer abc.DEF-_~+/==")
+	assert.True(t, ok)
+	assert.Equal(t, "abc.DEF-_~+/==", token)
+	for _, bad := range []string{"Bearer", "Bearer ", "Bearer a=b", "Bearer a b", "Basic abc"} {
+		_, ok = ParseBearer(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	users, err := ParseHtpasswd(strings.NewReader("ada:" + HashPassword("s3cret") + "\n"))
+	require.NoError(t, err)
+	tokens := NewStaticTokens(map[string]string{"tok-ci-123": "ci", "tok-grafana-456": "grafana"})
+	h := Middleware(Config{Realm: "admin", Basic: users.Verify, Bearer: tokens.Verify})(whoami)
+
+	// Test: Valid credentials go through with their principal
+	out := run(t, h, get(basic("ada", "s3cret")))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nBasic:ada"))
+	out = run(t, h, get("Bearer tok-grafana-456"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nBearer:grafana"))
+
+	// Test: No credentials, both challenges
+	out = run(t, h, get(""))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, out, `www-authenticate: Basic realm="admin", charset="UTF-8", Bearer realm="admin"`+"\r\n")
+
+	// Test: Wrong password, unknown token, broken token
+	out = run(t, h, get(basic("ada", "wrong")))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	out = run(t, h, get("Bearer tok-nope"))
+	assert.Contains(t, out, `Bearer realm="admin", error="invalid_token"`)
+	out = run(t, h, get("Bearer a b"))
+	assert.Contains(t, out, `error="invalid_request"`)
+
+	// Test: Schemes that aren't enabled don't count
+	basicOnly := Middleware(Config{Basic: users.Verify})(whoami)
+	out = run(t, basicOnly, get("Bearer tok-ci-123"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, out, `www-authenticate: Basic realm="restricted", charset="UTF-8"`+"\r\n")
+	assert.NotContains(t, out, "Bearer")
+
+	// Test: No scheme at all is a mistake, not a 401 without challenge
+	assert.Panics(t, func() { Middleware(Config{Realm: "admin"}) })
+}
@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// The Digest algorithms we speak. No "-sess" variants and no qop=auth-int.
+const (
+	DigestSHA256 = "SHA-256"
+	DigestMD5    = "MD5" // for the devices that know nothing else
+)
+
+// DigestHA1 is H(user:realm:password), what a digest credentials store keeps instead of the password.
+// Returns "" for an unknown algorithm.
+func DigestHA1(algorithm, user, realm, password string) string {
+	return digestHash(algorithm, user+":"+realm+":"+password)
+}
+
+func digestHash(algorithm, data string) string {
+	var h hash.Hash
+	switch algorithm {
+	case DigestSHA256:
+		h = sha256.New()
+	case DigestMD5:
+		h = md5.New()
+	default:
+		return ""
+	}
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestCredentials returns the HA1 (see DigestHA1) of a user for the realm and algorithm.
+type DigestCredentials func(user, realm, algorithm string) (ha1 string, ok bool)
+
+// DigestPasswords serves HA1s computed from plain passwords, fine for a handful of device accounts.
+func DigestPasswords(passwords map[string]string) DigestCredentials {
+	return func(user, realm, algorithm string) (string, bool) {
+		password, ok := passwords[user]
+		if !ok {
+			return "", false
+		}
+		return DigestHA1(algorithm, user, realm, password), true
+	}
+}
+
+// DigestConfig of the Digest middleware.
+type DigestConfig struct {
+	// Realm names the protection space, "restricted" when empty. It is part of every HA1.
+	Realm string
+	// Credentials looks the users up.
+	Credentials DigestCredentials
+	// Algorithms offered in the challenge, preferred first. SHA-256 then MD5 when empty.
+	Algorithms []string
+	// NonceTTL is how long a nonce is good for, 5 minutes when zero. After that the client
+	// gets stale=true and retries with a new nonce, without asking the user again.
+	NonceTTL time.Duration
+	// MaxNonces caps the nonces tracked for replay protection, 10000 when zero. Only the nonces
+	// a client logged in with count, not every challenge sent. Past the cap the oldest is forgotten
+	// and its client gets stale=true on its next request.
+	MaxNonces int
+}
+
+// Digest authenticates requests with HTTP Digest (RFC 7616), qop=auth only.
+//
+// Nonces carry their expiry and are signed with a key made at startup, sending one costs no memory.
+// A client with the right password on a nonce that isn't good anymore (expired, forgotten, or from
+// before a restart) gets stale=true and retries on a new one without asking the user again.
+// The nonce counts of the nonces in use are remembered: a request replaying an nc already seen
+// (or an older one) is refused, so clients must not reorder their requests.
+type Digest struct {
+	dcfg   DigestConfig
+	key    []byte
+	opaque string
+	now    func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]*list.Element // of *nonceState
+	used      *list.List               // in order of first use, the oldest is forgotten first
+	forgotten time.Time                // latest expiry of a forgotten nonce
+}
+
+type nonceState struct {
+	nonce   string
+	expires time.Time
+	lastNC  uint64
+}
+
+func NewDigest(cfg DigestConfig) (*Digest, error) {
+	if cfg.Credentials == nil {
+		return nil, fmt.Errorf("digest auth needs Credentials")
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "restricted"
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{DigestSHA256, DigestMD5}
+	}
+	for _, alg := range cfg.Algorithms {
+		if digestHash(alg, "") == "" {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+		}
+	}
+	if cfg.NonceTTL <= 0 {
+		cfg.NonceTTL = 5 * time.Minute
+	}
+	if cfg.MaxNonces <= 0 {
+		cfg.MaxNonces = 10000
+	}
+
+	key := make([]byte, 32)
+	opaque := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(opaque); err != nil {
+		return nil, err
+	}
+	return &Digest{
+		dcfg:   cfg,
+		key:    key,
+		opaque: hex.EncodeToString(opaque),
+		now:    time.Now,
+		nonces: map[string]*list.Element{},
+		used:   list.New(),
+	}, nil
+}
+
+// Middleware lets through the requests with a valid Digest response, the principal in their context.
+// A malformed Authorization gets a 400, anything else unauthenticated a 401 with the challenges.
+func (d *Digest) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			header, err := req.Headers.Get([]byte("Authorization"))
+			if err != nil {
+				d.challenge(w, false)
+				return
+			}
+			credentials, err := headers.ParseAuthChallenges(header)
+			if err != nil || len(credentials) != 1 || !strings.EqualFold(credentials[0].Scheme, "Digest") {
+				d.challenge(w, false)
+				return
+			}
+
+			user, info, result := d.check(req, credentials[0].Params)
+			switch result {
+			case digestMalformed:
+				response.Error(w, response.StatusBadRequest, "malformed digest credentials\n")
+				return
+			case digestStale:
+				d.challenge(w, true)
+				return
+			case digestDenied:
+				d.challenge(w, false)
+				return
+			}
+
+			w.Header().Set("Authentication-Info", info)
+			next(w, req.WithContext(ContextWithPrincipal(req.Context(), &Principal{Name: user, Scheme: "Digest"})))
+		}
+	}
+}
+
+type digestResult int
+
+const (
+	digestOK digestResult = iota
+	digestMalformed
+	digestDenied
+	digestStale // right password, but the nonce expired, was forgotten or is from before a restart
+)
+
+// check verifies the credentials, returning the user and the Authentication-Info value when they pass.
+func (d *Digest) check(req *request.Request, p map[string]string) (string, string, digestResult) {
+	user, nonce, uri, resp, qop, nc, cnonce := p["username"], p["nonce"], p["uri"], p["response"], p["qop"], p["nc"], p["cnonce"]
+	if user == "" || nonce == "" || uri == "" || resp == "" || cnonce == "" || len(nc) != 8 {
+		return "", "", digestMalformed
+	}
+	count, err := strconv.ParseUint(nc, 16, 32)
+	if err != nil || count == 0 {
+		return "", "", digestMalformed
+	}
+	if qop != "auth" || p["userhash"] == "true" {
+		return "", "", digestDenied
+	}
+	// The uri must be the one requested, or the response could be replayed on another resource.
+	// The opaque isn't checked, after a restart it changed along with the key, the nonce says it all.
+	if uri != req.RequestLine.RequestTarget || p["realm"] != d.dcfg.Realm {
+		return "", "", digestDenied
+	}
+
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = DigestMD5 // the RFC default when left out
+	}
+	offered := false
+	for _, alg := range d.dcfg.Algorithms {
+		offered = offered || strings.EqualFold(alg, algorithm)
+	}
+	if !offered {
+		return "", "", digestDenied
+	}
+	algorithm = strings.ToUpper(algorithm)
+
+	ha1, known := d.dcfg.Credentials(user, d.dcfg.Realm, algorithm)
+	if !known {
+		// Same work as for a known user, the timing doesn't tell who exists.
+		ha1 = DigestHA1(algorithm, user, d.dcfg.Realm, "")
+	}
+	ha2 := digestHash(algorithm, req.RequestLine.Method+":"+uri)
+	want := digestHash(algorithm, ha1+":"+nonce+":"+nc+":"+cnonce+":"+qop+":"+ha2)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(resp))) != 1 || !known {
+		return "", "", digestDenied
+	}
+
+	// Only now that the password checks out, look at the nonce: one that isn't good anymore is stale.
+	if result := d.useNonce(nonce, count); result != digestOK {
+		return "", "", result
+	}
+
+	// rspauth proves to the client that we know its password too (RFC 7616 section 3.5).
+	rspauth := digestHash(algorithm, ha1+":"+nonce+":"+nc+":"+cnonce+":"+qop+":"+digestHash(algorithm, ":"+uri))
+	info := fmt.Sprintf(`qop=auth, rspauth="%s", cnonce=%s, nc=%s`, rspauth, headers.QuoteString(cnonce), nc)
+	return user, info, digestOK
+}
+
+// newNonce is base64url(expiry || random || hmac), checkable without any state.
+func (d *Digest) newNonce() string {
+	expires := d.now().Add(d.dcfg.NonceTTL)
+	buf := make([]byte, 8+12, 8+12+16)
+	binary.BigEndian.PutUint64(buf, uint64(expires.UnixNano()))
+	rand.Read(buf[8:])
+	buf = append(buf, d.mac(buf)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (d *Digest) mac(data []byte) []byte {
+	m := hmac.New(sha256.New, d.key)
+	m.Write(data)
+	return m.Sum(nil)[:16]
+}
+
+// openNonce checks the nonce is one of ours and returns its expiry.
+func (d *Digest) openNonce(nonce string) (time.Time, bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 8+12+16 {
+		return time.Time{}, false
+	}
+	if !hmac.Equal(buf[20:], d.mac(buf[:20])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(buf))), true
+}
+
+// useNonce records the nonce count. A nonce that isn't ours, expired or was forgotten is stale,
+// a count already used a replay.
+func (d *Digest) useNonce(nonce string, count uint64) digestResult {
+	expires, ok := d.openNonce(nonce)
+	now := d.now()
+	if !ok || now.After(expires) {
+		return digestStale
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.nonces[nonce]; ok {
+		state := e.Value.(*nonceState)
+		if count <= state.lastNC {
+			return digestDenied
+		}
+		state.lastNC = count
+		return digestOK
+	}
+
+	// Not tracked: never used, or used and then forgotten, in which case its counts can't be
+	// checked anymore. Only a nonce newer than everything forgotten is surely a fresh one.
+	if !expires.After(d.forgotten) {
+		return digestStale
+	}
+	d.trackNonce(&nonceState{nonce: nonce, expires: expires, lastNC: count}, now)
+	return digestOK
+}
+
+// trackNonce remembers a nonce in use, forgetting the oldest one past MaxNonces.
+// Called with the lock held.
+func (d *Digest) trackNonce(state *nonceState, now time.Time) {
+	// The expired ones are refused on their date anyway, no need to remember them as forgotten.
+	for e := d.used.Front(); e != nil && now.After(e.Value.(*nonceState).expires); e = d.used.Front() {
+		d.forgetNonce(e)
+	}
+	if d.used.Len() >= d.dcfg.MaxNonces {
+		oldest := d.used.Front()
+		if expires := oldest.Value.(*nonceState).expires; expires.After(d.forgotten) {
+			d.forgotten = expires
+		}
+		d.forgetNonce(oldest)
+	}
+	d.nonces[state.nonce] = d.used.PushBack(state)
+}
+
+func (d *Digest) forgetNonce(e *list.Element) {
+	delete(d.nonces, e.Value.(*nonceState).nonce)
+	d.used.Remove(e)
+}
+
+// challenge answers 401 with a Digest challenge per algorithm, all on the same fresh nonce.
+func (d *Digest) challenge(w *response.Writer, stale bool) {
+	nonce := d.newNonce()
+	var challenges []string
+	for _, alg := range d.dcfg.Algorithms {
+		c := fmt.Sprintf(`Digest realm=%s, qop="auth", algorithm=%s, nonce="%s", opaque="%s"`,
+			headers.QuoteString(d.dcfg.Realm), alg, nonce, d.opaque)
+		if stale {
+			c += ", stale=true"
+		}
+		challenges = append(challenges, c)
+	}
+	w.Header().Set("WWW-Authenticate", strings.Join(challenges, ", "))
+	response.Error(w, response.StatusUnauthorized, "unauthorized\n")
+}
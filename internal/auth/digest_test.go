@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// header is the value of a response header, from the raw response.
+func header(out, name string) string {
+	for _, line := range strings.Split(out, "\r\n") {
+		if value, ok := strings.CutPrefix(line, name+": "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestDigest(t *testing.T) {
+	d, err := NewDigest(DigestConfig{Realm: "devices", Credentials: DigestPasswords(map[string]string{"ada": "s3cret"})})
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	h := d.Middleware()(whoami)
+
+	// Test: No credentials, a challenge per algorithm, SHA-256 first
+	out := run(t, h, get(""))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	challenge := header(out, "www-authenticate")
+	assert.True(t, strings.HasPrefix(challenge, `Digest realm="devices", qop="auth", algorithm=SHA-256, nonce="`))
+	assert.Contains(t, challenge, `, Digest realm="devices", qop="auth", algorithm=MD5, `)
+	assert.NotContains(t, challenge, "stale")
+
+	// Test: The client answers, the server proves it knows the password too
+	client := &DigestClient{Username: "ada", Password: "s3cret"}
+	authorization, err := client.AnswerChallenge(challenge, "GET", "/admin")
+	require.NoError(t, err)
+	assert.Contains(t, authorization, "algorithm=SHA-256")
+	out = run(t, h, get(authorization))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nDigest:ada"))
+	assert.Contains(t, header(out, "authentication-info"), `qop=auth, rspauth="`)
+
+	// Test: The nonce is reused with the next nc, a replay is refused
+	next, err := client.Authorization("GET", "/admin")
+	require.NoError(t, err)
+	out = run(t, h, get(next))
+	assert.True(t, strings.HasSuffix(out, "Digest:ada"))
+	out = run(t, h, get(next))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.NotContains(t, header(out, "www-authenticate"), "stale")
+
+	// Test: Another uri than the one requested
+	other, err := client.Authorization("GET", "/other")
+	require.NoError(t, err)
+	out = run(t, h, get(other))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+
+	// Test: Wrong password, unknown user
+	for _, c := range []*DigestClient{{Username: "ada", Password: "nope"}, {Username: "eve", Password: "s3cret"}} {
+		authorization, err := c.AnswerChallenge(challenge, "GET", "/admin")
+		require.NoError(t, err)
+		out = run(t, h, get(authorization))
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	}
+
+	// Test: An expired nonce with the right password is stale, the client retries on the new one
+	now = now.Add(6 * time.Minute)
+	late, err := client.Authorization("GET", "/admin")
+	require.NoError(t, err)
+	out = run(t, h, get(late))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	renewed := header(out, "www-authenticate")
+	assert.Contains(t, renewed, "stale=true")
+	retry, err := client.AnswerChallenge(renewed, "GET", "/admin")
+	require.NoError(t, err)
+	out = run(t, h, get(retry))
+	assert.True(t, strings.HasSuffix(out, "Digest:ada"))
+
+	// Test: A forged nonce isn't stale, just wrong: the responses are correct over a nonce with a bad MAC,
+	// the right password gets a new nonce, a wrong one doesn't
+	_, rest, _ := strings.Cut(renewed, `nonce="`)
+	nonce, _, _ := strings.Cut(rest, `"`)
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	require.NoError(t, err)
+	raw[len(raw)-1] ^= 1
+	forgedChallenge := strings.ReplaceAll(renewed, nonce, base64.RawURLEncoding.EncodeToString(raw))
+	forged, err := (&DigestClient{Username: "ada", Password: "s3cret"}).AnswerChallenge(forgedChallenge, "GET", "/admin")
+	require.NoError(t, err)
+	out = run(t, h, get(forged))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, header(out, "www-authenticate"), "stale=true")
+	forged, err = (&DigestClient{Username: "ada", Password: "nope"}).AnswerChallenge(forgedChallenge, "GET", "/admin")
+	require.NoError(t, err)
+	out = run(t, h, get(forged))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.NotContains(t, header(out, "www-authenticate"), "stale")
+
+	// Test: Malformed credentials are a 400
+	out = run(t, h, get(`Digest username="ada", nonce="x", uri="/admin", response="y"`))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestDigestMD5(t *testing.T) {
+	ha1 := DigestHA1(DigestMD5, "cam1", "legacy", "pass")
+	d, err := NewDigest(DigestConfig{
+		Realm:      "legacy",
+		Algorithms: []string{DigestMD5},
+		Credentials: func(user, realm, algorithm string) (string, bool) {
+			return ha1, user == "cam1" && algorithm == DigestMD5
+		},
+	})
+	require.NoError(t, err)
+	h := d.Middleware()(whoami)
+
+	out := run(t, h, get(""))
+	challenge := header(out, "www-authenticate")
+	assert.NotContains(t, challenge, "SHA-256")
+
+	client := &DigestClient{Username: "cam1", Password: "pass"}
+	authorization, err := client.AnswerChallenge(challenge, "GET", "/admin")
+	require.NoError(t, err)
+	assert.Contains(t, authorization, "algorithm=MD5")
+	out = run(t, h, get(authorization))
+	assert.True(t, strings.HasSuffix(out, "Digest:cam1"))
+
+	// Test: An algorithm the server doesn't offer
+	sha := strings.Replace(authorization, "algorithm=MD5", "algorithm=SHA-256", 1)
+	out = run(t, h, get(sha))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+
+	_, err = NewDigest(DigestConfig{Credentials: DigestPasswords(nil), Algorithms: []string{"SHA-512-256"}})
+	assert.Error(t, err)
+	assert.ErrorIs(t, (&DigestClient{}).Challenge(`Basic realm="x"`), ErrNoDigestChallenge)
+}
+
+func TestDigestRestart(t *testing.T) {
+	cfg := DigestConfig{Realm: "devices", Credentials: DigestPasswords(map[string]string{"ada": "s3cret"})}
+	before, err := NewDigest(cfg)
+	require.NoError(t, err)
+	after, err := NewDigest(cfg)
+	require.NoError(t, err)
+
+	client := &DigestClient{Username: "ada", Password: "s3cret"}
+	authorization, err := client.AnswerChallenge(header(run(t, before.Middleware()(whoami), get("")), "www-authenticate"), "GET", "/admin")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(run(t, before.Middleware()(whoami), get(authorization)), "Digest:ada"))
+
+	// Test: After a restart the nonce (and opaque) of the old process are stale, not wrong
+	h := after.Middleware()(whoami)
+	next, err := client.Authorization("GET", "/admin")
+	require.NoError(t, err)
+	out := run(t, h, get(next))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, header(out, "www-authenticate"), "stale=true")
+	retry, err := client.AnswerChallenge(header(out, "www-authenticate"), "GET", "/admin")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(run(t, h, get(retry)), "Digest:ada"))
+
+	// Test: Not with the wrong password
+	wrong := &DigestClient{Username: "ada", Password: "nope"}
+	require.NoError(t, wrong.Challenge(header(run(t, before.Middleware()(whoami), get("")), "www-authenticate")))
+	guess, err := wrong.Authorization("GET", "/admin")
+	require.NoError(t, err)
+	assert.NotContains(t, header(run(t, h, get(guess)), "www-authenticate"), "stale")
+}
+
+func TestDigestNonceTracking(t *testing.T) {
+	d, err := NewDigest(DigestConfig{Credentials: DigestPasswords(map[string]string{"ada": "a", "bob": "b", "cy": "c"}), MaxNonces: 2})
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	h := d.Middleware()(whoami)
+
+	login := func(user, password string) *DigestClient {
+		now = now.Add(time.Millisecond)
+		c := &DigestClient{Username: user, Password: password}
+		authorization, err := c.AnswerChallenge(header(run(t, h, get("")), "www-authenticate"), "GET", "/admin")
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(run(t, h, get(authorization)), "Digest:"+user))
+		return c
+	}
+	next := func(c *DigestClient) string {
+		authorization, err := c.Authorization("GET", "/admin")
+		require.NoError(t, err)
+		return run(t, h, get(authorization))
+	}
+
+	// Test: Anonymous challenges cost nothing, the logged in client keeps its nonce
+	ada := login("ada", "a")
+	for i := 0; i < 100; i++ {
+		run(t, h, get(""))
+	}
+	assert.Equal(t, 1, d.used.Len())
+	assert.True(t, strings.HasSuffix(next(ada), "Digest:ada"))
+
+	// Test: Past MaxNonces the oldest is forgotten, its next request is stale, not let through again
+	login("bob", "b")
+	login("cy", "c")
+	assert.Equal(t, 2, d.used.Len())
+	replay, err := ada.Authorization("GET", "/admin")
+	require.NoError(t, err)
+	out := run(t, h, get(replay))
+	assert.Contains(t, header(out, "www-authenticate"), "stale=true")
+	out = run(t, h, get(replay))
+	assert.Contains(t, header(out, "www-authenticate"), "stale=true")
+
+	// Test: The retry on a fresh nonce works
+	retry, err := ada.AnswerChallenge(header(out, "www-authenticate"), "GET", "/admin")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(run(t, h, get(retry)), "Digest:ada"))
+
+	// Test: Expired nonces are dropped first
+	now = now.Add(time.Hour)
+	login("bob", "b")
+	assert.Equal(t, 1, d.used.Len())
+}
@@ -0,0 +1,216 @@
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The organization written in every certificate, so they are easy to spot in a trust store.
+const organization = "boot.mossad.http development"
+
+var ErrInvalidCAKey = fmt.Errorf("certgen: CA private key cannot sign certificates")
+
+// CA is a local certificate authority used to sign development certificates.
+type CA struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// NewCA creates a self-signed CA certificate with a fresh P-256 key.
+func NewCA(commonName string, validFor time.Duration) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{organization}},
+		NotBefore:             now.Add(-time.Hour), // tolerate small clock skews
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCA(der, key)
+}
+
+// LoadCA reads a CA certificate and its private key from PEM files.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidCAKey
+	}
+
+	return newCA(pair.Certificate[0], key)
+}
+
+func newCA(der []byte, key crypto.Signer) (*CA, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certgen: %q is not a CA certificate", cert.Subject.CommonName)
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  keyPEM,
+	}, nil
+}
+
+// Issue signs a leaf certificate for the given hosts, each one is either a DNS name,
+// an IP address or a URI (e.g. spiffe://cluster/ns/default/sa/api).
+// The certificate is valid for both server and client auth, so the same command can produce mTLS client certs.
+func (ca *CA) Issue(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("certgen: at least one host is required")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl, err := leafTemplate(hosts, validFor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return certPEM, keyPEM, nil
+}
+
+// SelfSigned creates a throwaway certificate signed by a throwaway CA, used when the server starts
+// in TLS mode without a certificate. Nothing is written to disk.
+func SelfSigned(hosts []string, validFor time.Duration) (tls.Certificate, error) {
+	ca, err := NewCA("ephemeral development CA", validFor)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM, keyPEM, err := ca.Issue(hosts, validFor)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// WriteFiles writes the PEM files, the key is only readable by the owner.
+func WriteFiles(certFile string, certPEM []byte, keyFile string, keyPEM []byte) error {
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+// SplitHosts turns "localhost, 127.0.0.1" into its trimmed, non-empty parts.
+func SplitHosts(list string) []string {
+	var hosts []string
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+func leafTemplate(hosts []string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{organization}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			continue
+		}
+		if strings.Contains(h, "://") {
+			u, err := url.Parse(h)
+			if err != nil {
+				return nil, fmt.Errorf("certgen: invalid URI %q: %w", h, err)
+			}
+			tmpl.URIs = append(tmpl.URIs, u)
+			continue
+		}
+		tmpl.DNSNames = append(tmpl.DNSNames, h)
+	}
+
+	return tmpl, nil
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// 128 bits, as recommended for serial numbers.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,67 @@
+package certgen
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := NewCA("test CA", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+
+	certPEM, keyPEM, err := ca.Issue([]string{"localhost", "127.0.0.1", "spiffe://dev/ns/ops/sa/admin"}, time.Hour)
+	require.NoError(t, err)
+	require.NotEmpty(t, keyPEM)
+
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	// Test: Every host landed in the right SAN
+	assert.Equal(t, []string{"localhost"}, leaf.DNSNames)
+	require.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+	require.Len(t, leaf.URIs, 1)
+	assert.Equal(t, "spiffe://dev/ns/ops/sa/admin", leaf.URIs[0].String())
+
+	// Test: The leaf verifies against the CA, for both server and client usage
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+	require.NoError(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	require.NoError(t, err)
+
+	// Test: No hosts
+	_, _, err = ca.Issue(nil, time.Hour)
+	require.Error(t, err)
+}
+
+func TestLoadCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := NewCA("test CA", time.Hour)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, WriteFiles(certFile, ca.CertPEM, keyFile, ca.KeyPEM))
+
+	loaded, err := LoadCA(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, ca.Cert.SerialNumber, loaded.Cert.SerialNumber)
+
+	// Test: A leaf isn't a CA
+	leafPEM, leafKey, err := ca.Issue([]string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, WriteFiles(certFile, leafPEM, keyFile, leafKey))
+	_, err = LoadCA(certFile, keyFile)
+	require.Error(t, err)
+}
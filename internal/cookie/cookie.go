@@ -0,0 +1,253 @@
+// Package cookie reads the Cookie header and builds Set-Cookie lines (RFC 6265).
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+)
+
+var (
+	ErrInvalidName      = fmt.Errorf("invalid cookie name")
+	ErrInvalidValue     = fmt.Errorf("invalid cookie value")
+	ErrInvalidPath      = fmt.Errorf("invalid cookie path")
+	ErrInvalidDomain    = fmt.Errorf("invalid cookie domain")
+	ErrInvalidExpires   = fmt.Errorf("invalid cookie expires")
+	ErrInsecureCookie   = fmt.Errorf("cookie needs Secure")
+	ErrInvalidSetCookie = fmt.Errorf("malformed Set-Cookie")
+)
+
+// SameSite is the SameSite attribute, left out by default (browsers treat that as Lax nowadays).
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie sent by the client (only Name and Value then) or set by the server.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time // zero for none
+	// MaxAge in seconds: zero leaves it out, negative deletes the cookie now (Max-Age=0).
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool // CHIPS, third party cookies keyed on the top level site
+}
+
+// Valid checks the cookie can go in a Set-Cookie line as is. Values are never quoted or escaped
+// for you, encode anything outside the cookie-octet set (base64url works) before setting it.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("%w: %q", ErrInvalidValue, c.Value)
+	}
+	if !validAttr(c.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, c.Path)
+	}
+	if !validDomain(c.Domain) {
+		return fmt.Errorf("%w: %q", ErrInvalidDomain, c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: %v", ErrInvalidExpires, c.Expires)
+	}
+	// Browsers drop these without Secure anyway, better to find out here.
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return ErrInsecureCookie
+	}
+	return nil
+}
+
+// String is the Set-Cookie value, or the name=value pair for a cookie without attributes.
+// It doesn't validate, see Valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// A leading dot is from RFC 2109, ignored by RFC 6265
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse reads a Cookie request header, "a=1; b=2". Pairs that aren't valid are skipped rather
+// than failing the whole header, the client may hold cookies set by someone less careful.
+// Quotes around a value are removed.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		value, ok = unquote(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie reads a Set-Cookie line, for clients and tests. Unknown attributes are ignored.
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || !isToken(name) {
+		return nil, ErrInvalidSetCookie
+	}
+	value, ok = unquote(value)
+	if !ok {
+		return nil, ErrInvalidSetCookie
+	}
+	c := &Cookie{Name: name, Value: value}
+
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = strings.TrimPrefix(val, ".")
+		case "expires":
+			if t, err := headers.ParseTime(val); err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				continue
+			}
+			if n <= 0 {
+				n = -1
+			}
+			c.MaxAge = n
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+func unquote(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return value, validValue(value)
+}
+
+// cookie-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E
+// (no space, DQUOTE, comma, semicolon or backslash)
+func validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// Path and the other free form attributes: anything but controls and ";".
+func validAttr(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if len(domain) > 253 {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// token = 1*tchar (RFC 9110 section 5.6.2)
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs, quotes, spaces
+	cookies := Parse(`sid=abc123; theme="dark";lang=en`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "sid", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "en", cookies[2].Value)
+
+	// Test: Broken pairs are skipped, the rest survives
+	cookies = Parse(`noequals; bad name=1; ok=1; v=a b; empty=`)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "ok", cookies[0].Name)
+	assert.Equal(t, "empty", cookies[1].Name)
+	assert.Equal(t, "", cookies[1].Value)
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestCookieString(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Cookie{
+		Name: "sid", Value: "abc123",
+		Path: "/app", Domain: ".example.com", Expires: expires, MaxAge: 3600,
+		Secure: true, HttpOnly: true, SameSite: SameSiteNone, Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	line := c.String()
+	assert.Equal(t, "sid=abc123; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", line)
+
+	// Test: What we write, we read back
+	back, err := ParseSetCookie(line)
+	require.NoError(t, err)
+	c.Domain = "example.com"
+	assert.Equal(t, c, back)
+
+	// Test: Deleting
+	assert.Equal(t, "sid=; Max-Age=0", (&Cookie{Name: "sid", MaxAge: -1}).String())
+}
+
+func TestCookieValid(t *testing.T) {
+	for _, tc := range []struct {
+		cookie Cookie
+		err    error
+	}{
+		{Cookie{Name: "", Value: "1"}, ErrInvalidName},
+		{Cookie{Name: "a b", Value: "1"}, ErrInvalidName},
+		{Cookie{Name: "a", Value: `x"y`}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: "x;y"}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: "x,y"}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: "1", Path: "/;Secure"}, ErrInvalidPath},
+		{Cookie{Name: "a", Value: "1", Domain: "exa mple.com"}, ErrInvalidDomain},
+		{Cookie{Name: "a", Value: "1", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)}, ErrInvalidExpires},
+		{Cookie{Name: "a", Value: "1", SameSite: SameSiteNone}, ErrInsecureCookie},
+		{Cookie{Name: "a", Value: "1", Partitioned: true}, ErrInsecureCookie},
+	} {
+		assert.ErrorIs(t, tc.cookie.Valid(), tc.err, tc.cookie.String())
+	}
+	assert.NoError(t, (&Cookie{Name: "a", Value: "bG9yZW0_aXBzdW0-"}).Valid())
+}
@@ -0,0 +1,135 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+)
+
+// ServeContent answers with the content, honouring Range and If-Range. Any handler can use it.
+//
+// The Content-Type is taken from w.Header() when already set, from the extension of name or
+// from the first bytes otherwise. modTime (when not zero) becomes Last-Modified, set the ETag
+// in w.Header() beforehand to have one. Both are used for the conditional headers
+// (If-None-Match, If-Modified-Since, ... answered with 304/412) and for If-Range.
+func ServeContent(w *response.Writer, req *request.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		response.Error(w, response.StatusInternalServerError, "seek error\n")
+		return
+	}
+
+	h := w.Header()
+	contentType, _ := h.Get([]byte("Content-Type"))
+	if contentType == "" {
+		contentType = ContentTypeByExtension(name)
+	}
+	if contentType == "" {
+		head := make([]byte, sniffLen)
+		n, _ := io.ReadFull(content, head)
+		contentType = DetectContentType(head[:n])
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			response.Error(w, response.StatusInternalServerError, "seek error\n")
+			return
+		}
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Accept-Ranges", "bytes")
+
+	etag, _ := h.Get([]byte("ETag"))
+	if response.CheckPreconditions(w, req, etag, modTime) {
+		return
+	}
+
+	var ranges []ByteRange
+	if rangeHeader, err := req.Headers.Get([]byte("Range")); err == nil && ifRangeMatches(w, req, modTime) {
+		ranges, err = ParseRange(rangeHeader, size)
+		switch {
+		case err == ErrNoOverlap:
+			h.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			response.Error(w, response.StatusRangeNotSatisfiable, "range not satisfiable\n")
+			return
+		case err != nil, totalLength(ranges) > size:
+			// A broken header, or ranges asking for more than the whole thing: send the whole thing.
+			ranges = nil
+		}
+	}
+
+	head := req.RequestLine.Method == "HEAD"
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(response.StatusOK)
+		if !head {
+			io.CopyN(w, content, size)
+		}
+
+	case 1:
+		r := ranges[0]
+		h.Set("Content-Range", r.ContentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.Length, 10))
+		w.WriteHeader(response.StatusPartialContent)
+		if !head {
+			if _, err := content.Seek(r.Start, io.SeekStart); err == nil {
+				io.CopyN(w, content, r.Length)
+			}
+		}
+
+	default:
+		boundary := newBoundary()
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(multipartLength(boundary, contentType, ranges, size), 10))
+		w.WriteHeader(response.StatusPartialContent)
+		if head {
+			return
+		}
+		for _, r := range ranges {
+			if _, err := io.WriteString(w, partHeader(boundary, contentType, r, size)); err != nil {
+				return
+			}
+			if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+				return
+			}
+			if _, err := io.CopyN(w, content, r.Length); err != nil {
+				return
+			}
+		}
+		io.WriteString(w, multipartTrailer(boundary))
+	}
+}
+
+// ifRangeMatches is true without If-Range, or when it still names the current version of the content.
+// Only strong validators count: an exact ETag, or a date equal to the modification time.
+func ifRangeMatches(w *response.Writer, req *request.Request, modTime time.Time) bool {
+	ifRange, err := req.Headers.Get([]byte("If-Range"))
+	if err != nil {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		want, ok := headers.ParseETag(ifRange)
+		value, _ := w.Header().Get([]byte("ETag"))
+		current, hasETag := headers.ParseETag(value)
+		return ok && hasETag && want.StrongMatch(current)
+	}
+
+	t, err := headers.ParseTime(ifRange)
+	return err == nil && !modTime.IsZero() && modTime.UTC().Truncate(time.Second).Equal(t)
+}
+
+func newBoundary() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
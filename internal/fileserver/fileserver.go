@@ -0,0 +1,233 @@
+// Package fileserver serves static files (frontend builds, assets, ...) from a directory or an fs.FS.
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// Options of the file server, the zero value serves index.html for directories and no listings.
+type Options struct {
+	// Prefix is stripped from the request path before looking the file up, e.g. "/static".
+	Prefix string
+	// Index is served for a directory, "index.html" when empty.
+	Index string
+	// Listing renders the content of directories without an index file, they are 404 otherwise.
+	Listing bool
+}
+
+// Dir opens a directory as an fs.FS that can't be escaped: ".." and symlinks pointing
+// outside of root are refused by os.Root, symlinks inside of it work as usual.
+func Dir(root string) (fs.FS, error) {
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	return r.FS(), nil
+}
+
+// New serves the files of fsys, an embed.FS works as is.
+func New(fsys fs.FS, opts Options) server.Handler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	opts.Prefix = strings.TrimSuffix(opts.Prefix, "/")
+
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			response.Error(w, response.StatusMethodNotAllowed, "method not allowed\n")
+			return
+		}
+
+		urlPath, ok := requestPath(req.RequestLine.RequestTarget, opts.Prefix)
+		if !ok {
+			response.Error(w, response.StatusNotFound, "not found\n")
+			return
+		}
+
+		name, ok := resolve(urlPath)
+		if !ok {
+			response.Error(w, response.StatusNotFound, "not found\n")
+			return
+		}
+
+		serve(w, req, fsys, opts, urlPath, name)
+	}
+}
+
+// requestPath is the decoded path of the target, with the prefix stripped.
+func requestPath(target, prefix string) (string, bool) {
+	rawPath, _, _ := strings.Cut(target, "?")
+	p, err := url.PathUnescape(rawPath)
+	if err != nil || !strings.HasPrefix(p, "/") {
+		return "", false
+	}
+
+	if prefix != "" {
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return "", false
+		}
+		p = strings.TrimPrefix(p, prefix)
+		if p == "" {
+			p = "/"
+		}
+	}
+	return p, true
+}
+
+// resolve turns the url path into an fs.FS name. Cleaning a rooted path drops every "..",
+// so "/../../etc/passwd" is just "etc/passwd" inside of the root, fs.ValidPath double checks it.
+func resolve(urlPath string) (string, bool) {
+	if strings.ContainsRune(urlPath, 0) {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean(urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+func serve(w *response.Writer, req *request.Request, fsys fs.FS, opts Options, urlPath, name string) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		openError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		openError(w, err)
+		return
+	}
+
+	// The redirects are built from the cleaned path: "//evil.example/.." must not become a
+	// Location the browser reads as another host.
+	cleaned := path.Clean(urlPath)
+
+	if info.IsDir() {
+		// Relative links in the index page only work with the trailing slash.
+		if !strings.HasSuffix(urlPath, "/") {
+			redirect(w, req, opts.Prefix+strings.TrimSuffix(cleaned, "/")+"/")
+			return
+		}
+
+		index, err := fsys.Open(path.Join(name, opts.Index))
+		if err == nil {
+			defer index.Close()
+			indexInfo, err := index.Stat()
+			if err == nil && !indexInfo.IsDir() {
+				serveFile(w, req, index, indexInfo)
+				return
+			}
+		}
+
+		if !opts.Listing {
+			response.Error(w, response.StatusNotFound, "not found\n")
+			return
+		}
+		dirList(w, req, f, opts.Prefix+urlPath)
+		return
+	}
+
+	// /index.html is the same page as /, keep a single url for it.
+	if path.Base(urlPath) == opts.Index {
+		redirect(w, req, opts.Prefix+strings.TrimSuffix(cleaned, opts.Index))
+		return
+	}
+
+	serveFile(w, req, f, info)
+}
+
+// serveFile streams the file, nothing but the first block (for sniffing) is held in memory.
+// Seekable files (os, embed.FS) go through ServeContent and get Range support.
+func serveFile(w *response.Writer, req *request.Request, f fs.File, info fs.FileInfo) {
+	if !info.Mode().IsRegular() {
+		response.Error(w, response.StatusNotFound, "not found\n")
+		return
+	}
+
+	if modTime := info.ModTime(); !modTime.IsZero() {
+		w.Header().Set("ETag", fileETag(modTime, info.Size()))
+	}
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		ServeContent(w, req, info.Name(), info.ModTime(), rs)
+		return
+	}
+
+	var head []byte
+	contentType := ContentTypeByExtension(info.Name())
+	if contentType == "" {
+		head = make([]byte, sniffLen)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			response.Error(w, response.StatusInternalServerError, "read error\n")
+			return
+		}
+		head = head[:n]
+		contentType = DetectContentType(head)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	etag, _ := h.Get([]byte("ETag"))
+	if response.CheckPreconditions(w, req, etag, info.ModTime()) {
+		return
+	}
+	w.WriteHeader(response.StatusOK)
+
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+
+	if _, err := w.Write(head); err != nil {
+		return
+	}
+	// The client going away mid-file is not our problem, the server closes the connection anyway.
+	io.Copy(w, f)
+}
+
+// fileETag is built from the modification time and the size like nginx does, cheap and good enough
+// to notice a new build of the file. The mtime goes down to the nanosecond: it is used as a strong
+// tag (If-Range), two writes of the same size within a second must not look the same.
+func fileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// redirect sends the client to the path, the query is kept.
+func redirect(w *response.Writer, req *request.Request, p string) {
+	// A single leading slash, "//host" is another site to the browser.
+	p = "/" + strings.TrimLeft(p, "/")
+	location := (&url.URL{Path: p}).EscapedPath()
+	if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+		location += "?" + query
+	}
+	w.Header().Set("Location", location)
+	response.Error(w, response.StatusMovedPermanently, "moved permanently\n")
+}
+
+// openError hides the details, a path escaping the root looks like any missing file.
+func openError(w *response.Writer, err error) {
+	if errors.Is(err, fs.ErrPermission) {
+		response.Error(w, response.StatusForbidden, "forbidden\n")
+		return
+	}
+	response.Error(w, response.StatusNotFound, "not found\n")
+}
@@ -0,0 +1,193 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// get runs the handler on a raw request and splits the response.
+func get(t *testing.T, h server.Handler, raw string) (statusLine string, headers map[string]string, body string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	h(w, req)
+	require.NoError(t, w.Flush())
+
+	head, body, ok := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, ok)
+	lines := strings.Split(head, "\r\n")
+	headers = map[string]string{}
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+	return lines[0], headers, body
+}
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"index.html":        {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+	"app.js":            {Data: []byte("console.log(1)")},
+	"docs/readme":       {Data: []byte("<!DOCTYPE html><p>hi</p>")},
+	"docs/data":         {Data: []byte("\x89PNG\r\n\x1a\nxxxx")},
+	"docs/a file & <b>": {Data: []byte("plain text")},
+	"docs/sub/x.txt":    {Data: []byte("x")},
+	"assets/index.html": {Data: []byte("assets home")},
+	"assets/logo.svg":   {Data: []byte("<svg/>")},
+}
+
+func TestFileServer(t *testing.T) {
+	h := New(testFS, Options{})
+
+	// Test: "/" serves the index
+	status, headers, body := get(t, h, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "text/html; charset=utf-8", headers["content-type"])
+	assert.Equal(t, "13", headers["content-length"])
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", headers["last-modified"])
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	// Test: type by extension
+	_, headers, body = get(t, h, "GET /app.js?v=3 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "text/javascript; charset=utf-8", headers["content-type"])
+	assert.Equal(t, "console.log(1)", body)
+
+	// Test: type by sniffing when there is no extension
+	_, headers, _ = get(t, h, "GET /docs/readme HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "text/html; charset=utf-8", headers["content-type"])
+	_, headers, body = get(t, h, "GET /docs/data HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "image/png", headers["content-type"])
+	assert.Equal(t, "\x89PNG\r\n\x1a\nxxxx", body) // the sniffed bytes are not lost
+
+	// Test: HEAD has the headers but no body
+	status, headers, body = get(t, h, "HEAD /app.js HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "14", headers["content-length"])
+	assert.Empty(t, body)
+
+	// Test: directories get their trailing slash, /index.html goes back to the directory
+	status, headers, _ = get(t, h, "GET /assets?x=1 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 301 Moved Permanently", status)
+	assert.Equal(t, "/assets/?x=1", headers["location"])
+	_, headers, _ = get(t, h, "GET /assets/index.html HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "/assets/", headers["location"])
+	_, _, body = get(t, h, "GET /assets/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "assets home", body)
+
+	// Test: the redirects never point to another host
+	for target, want := range map[string]string{
+		"//evil.example/..":             "/",
+		"/%2fevil.example/..":           "/",
+		"//evil.example/../assets":      "/assets/",
+		"///evil.example/../index.html": "/",
+		"//assets/index.html":           "/assets/",
+		"/docs/../assets?next=//x":      "/assets/?next=//x",
+	} {
+		status, headers, _ = get(t, h, "GET "+target+" HTTP/1.1\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 301 Moved Permanently", status, target)
+		assert.Equal(t, want, headers["location"], target)
+	}
+
+	// Test: no listing by default
+	status, _, _ = get(t, h, "GET /docs/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
+
+	// Test: traversal stays inside the root
+	for _, target := range []string{"/../../etc/passwd", "/%2e%2e/%2e%2e/etc/passwd", "/docs/..%2f..%2fetc/passwd", "/nope", "/%00", "/%zz"} {
+		status, _, _ = get(t, h, "GET "+target+" HTTP/1.1\r\n\r\n")
+		assert.Equal(t, "HTTP/1.1 404 Not Found", status, target)
+	}
+	_, _, body = get(t, h, "GET /docs/../app.js HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "console.log(1)", body)
+
+	// Test: only GET and HEAD
+	status, headers, _ = get(t, h, "POST / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed", status)
+	assert.Equal(t, "GET, HEAD", headers["allow"])
+}
+
+func TestFileServerPrefixAndListing(t *testing.T) {
+	h := New(testFS, Options{Prefix: "/static/", Listing: true})
+
+	_, _, body := get(t, h, "GET /static/app.js HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "console.log(1)", body)
+
+	status, _, _ := get(t, h, "GET /staticapp.js HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
+
+	_, headers, _ := get(t, h, "GET /static/docs HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "/static/docs/", headers["location"])
+
+	// Test: the listing escapes names, folders first
+	status, headers, body = get(t, h, "GET /static/docs/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "text/html; charset=utf-8", headers["content-type"])
+	assert.Contains(t, body, "<title>Index of /static/docs/</title>")
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, `<a href="./a%20file%20&amp;%20%3Cb%3E">a file &amp; &lt;b&gt;</a>`)
+	assert.Less(t, strings.Index(body, `"./sub/"`), strings.Index(body, `"./data"`))
+
+	// Test: HEAD of a listing has the length of the page
+	_, headHeaders, headBody := get(t, h, "HEAD /static/docs/ HTTP/1.1\r\n\r\n")
+	assert.Empty(t, headBody)
+	assert.Equal(t, headers["content-length"], headHeaders["content-length"])
+}
+
+func TestDirSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644))
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public.txt"), []byte("public"), 0644))
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	require.NoError(t, os.Symlink("public.txt", filepath.Join(root, "alias.txt")))
+
+	fsys, err := Dir(root)
+	require.NoError(t, err)
+	h := New(fsys, Options{})
+
+	// Test: a symlink out of the root is refused, one inside of it works
+	status, _, _ := get(t, h, "GET /escape HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
+
+	_, headers, body := get(t, h, "GET /alias.txt HTTP/1.1\r\n\r\n")
+	assert.Equal(t, "public", body)
+	assert.Equal(t, "text/plain; charset=utf-8", headers["content-type"])
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := map[string]string{
+		"":                            "text/plain; charset=utf-8",
+		"hello":                       "text/plain; charset=utf-8",
+		"  <!DOCTYPE HTML><html>":     "text/html; charset=utf-8",
+		"<p>hi":                       "text/html; charset=utf-8",
+		"<pre>not a p":                "text/plain; charset=utf-8",
+		"<?xml version=\"1.0\"?>":     "text/xml; charset=utf-8",
+		"GIF89a...":                   "image/gif",
+		"RIFF\x00\x00\x00\x00WEBPVP8": "image/webp",
+		"%PDF-1.7":                    "application/pdf",
+		"\x00asm\x01\x00\x00\x00":     "application/wasm",
+		"\x00\x01\x02binary":          "application/octet-stream",
+		"caf\xc3":                     "text/plain; charset=utf-8", // truncated utf-8 at the end of the block
+	}
+	for data, want := range tests {
+		assert.Equal(t, want, DetectContentType([]byte(data)), "%q", data)
+	}
+}
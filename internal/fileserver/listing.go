@@ -0,0 +1,60 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+)
+
+// dirList renders a bare html page linking every entry of the directory, folders first.
+func dirList(w *response.Writer, req *request.Request, dir fs.File, urlPath string) {
+	rd, ok := dir.(fs.ReadDirFile)
+	if !ok {
+		response.Error(w, response.StatusForbidden, "forbidden\n")
+		return
+	}
+	entries, err := rd.ReadDir(-1)
+	if err != nil {
+		response.Error(w, response.StatusInternalServerError, "error reading directory\n")
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	// The page is small, render it first so HEAD gets the same Content-Length as GET.
+	var page bytes.Buffer
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&page, "<!doctype html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if urlPath != "/" {
+		fmt.Fprintf(&page, "<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		// "./" keeps a name like "a:b" from being read as a url scheme
+		href := "./" + (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&page, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	fmt.Fprintf(&page, "</ul>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.WriteHeader(response.StatusOK)
+	if req.RequestLine.Method != "HEAD" {
+		page.WriteTo(w)
+	}
+}
@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Past that many ranges the Range header is ignored and the whole content sent,
+// a thousand tiny ranges is a way to make us do a lot of work for nothing.
+const maxRanges = 32
+
+var (
+	ErrInvalidRange = fmt.Errorf("invalid range")
+	ErrNoOverlap    = fmt.Errorf("range not satisfiable")
+)
+
+// ByteRange is a resolved range, Start and Length are within the content.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange is the Content-Range value of the range.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange resolves a Range header against the content size.
+// ErrInvalidRange means the header should be ignored (bad syntax, other unit),
+// ErrNoOverlap that none of the ranges is in the content (416).
+// Ranges that are out of the content are dropped as long as one of them is left.
+func ParseRange(header string, size int64) ([]ByteRange, error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, ErrInvalidRange
+	}
+
+	var ranges []ByteRange
+	specs := 0
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, ErrInvalidRange
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue // "bytes=0-1,,5-6" is tolerated
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+		specs++
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r ByteRange
+		if first == "" {
+			// suffix range, the last N bytes
+			n, err := parseOffset(last)
+			if err != nil {
+				return nil, ErrInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = ByteRange{Start: size - n, Length: n}
+		} else {
+			start, err := parseOffset(first)
+			if err != nil {
+				return nil, ErrInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = parseOffset(last)
+				if err != nil || end < start {
+					return nil, ErrInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if specs == 0 {
+		return nil, ErrInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, ErrNoOverlap
+	}
+	return ranges, nil
+}
+
+// Only digits, no sign and no spaces inside.
+func parseOffset(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, ErrInvalidRange
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// totalLength is the number of bytes the ranges cover, overlaps counted twice.
+func totalLength(ranges []ByteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.Length
+	}
+	return n
+}
+
+// partHeader is what comes before the bytes of a range in a multipart/byteranges body.
+func partHeader(boundary, contentType string, r ByteRange, size int64) string {
+	return fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", boundary, contentType, r.ContentRange(size))
+}
+
+func multipartTrailer(boundary string) string {
+	return "\r\n--" + boundary + "--\r\n"
+}
+
+// multipartLength is the exact body size, so the response can carry a Content-Length.
+func multipartLength(boundary, contentType string, ranges []ByteRange, size int64) int64 {
+	n := int64(len(multipartTrailer(boundary)))
+	for _, r := range ranges {
+		n += int64(len(partHeader(boundary, contentType, r, size))) + r.Length
+	}
+	return n
+}
@@ -0,0 +1,161 @@
+package fileserver
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []ByteRange
+		err    error
+	}{
+		{"bytes=0-4", []ByteRange{{0, 5}}, nil},
+		{"bytes=5-", []ByteRange{{5, 5}}, nil},
+		{"bytes=-3", []ByteRange{{7, 3}}, nil},
+		{"bytes=-30", []ByteRange{{0, 10}}, nil},
+		{"bytes=8-100", []ByteRange{{8, 2}}, nil},
+		{"bytes= 0-0 , 2-3,", []ByteRange{{0, 1}, {2, 2}}, nil},
+		{"bytes=0-1,20-30", []ByteRange{{0, 2}}, nil}, // the unsatisfiable one is dropped
+		{"bytes=10-", nil, ErrNoOverlap},
+		{"bytes=-0", nil, ErrNoOverlap},
+		{"bytes=5-2", nil, ErrInvalidRange},
+		{"bytes=a-b", nil, ErrInvalidRange},
+		{"bytes=+1-2", nil, ErrInvalidRange},
+		{"bytes=", nil, ErrInvalidRange},
+		{"items=0-1", nil, ErrInvalidRange},
+		{"bytes=1", nil, ErrInvalidRange},
+		{"bytes=" + strings.Repeat("0-0,", 40), nil, ErrInvalidRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.header, 10)
+		assert.ErrorIs(t, err, tt.err, tt.header)
+		assert.Equal(t, tt.want, got, tt.header)
+	}
+}
+
+func serveContent(t *testing.T, raw string, setup func(w *response.Writer)) (string, map[string]string, string) {
+	t.Helper()
+	return get(t, func(w *response.Writer, req *request.Request) {
+		if setup != nil {
+			setup(w)
+		}
+		ServeContent(w, req, "data.txt", modTime, strings.NewReader("0123456789"))
+	}, raw)
+}
+
+func TestServeContentRanges(t *testing.T) {
+	// Test: no Range, the whole content
+	status, headers, body := serveContent(t, "GET / HTTP/1.1\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "bytes", headers["accept-ranges"])
+	assert.Equal(t, "text/plain; charset=utf-8", headers["content-type"])
+	assert.Equal(t, "0123456789", body)
+
+	// Test: single range
+	status, headers, body = serveContent(t, "GET / HTTP/1.1\r\nRange: bytes=2-5\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "bytes 2-5/10", headers["content-range"])
+	assert.Equal(t, "4", headers["content-length"])
+	assert.Equal(t, "2345", body)
+
+	// Test: several ranges make a multipart/byteranges body of the announced length
+	status, headers, body = serveContent(t, "GET / HTTP/1.1\r\nRange: bytes=0-1, -2\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	boundary, ok := strings.CutPrefix(headers["content-type"], "multipart/byteranges; boundary=")
+	require.True(t, ok)
+	assert.Equal(t, "\r\n--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 0-1/10\r\n\r\n01"+
+		"\r\n--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 8-9/10\r\n\r\n89"+
+		"\r\n--"+boundary+"--\r\n", body)
+	assert.Equal(t, strconv.Itoa(len(body)), headers["content-length"])
+
+	// Test: unsatisfiable
+	status, headers, _ = serveContent(t, "GET / HTTP/1.1\r\nRange: bytes=50-60\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 416 Range Not Satisfiable", status)
+	assert.Equal(t, "bytes */10", headers["content-range"])
+
+	// Test: garbage or greedy Range headers are ignored
+	for _, r := range []string{"bytes=x-y", "lines=1-2", "bytes=0-9,0-9"} {
+		status, _, body = serveContent(t, "GET / HTTP/1.1\r\nRange: "+r+"\r\n\r\n", nil)
+		assert.Equal(t, "HTTP/1.1 200 OK", status, r)
+		assert.Equal(t, "0123456789", body, r)
+	}
+
+	// Test: HEAD of a range, headers only
+	status, headers, body = serveContent(t, "HEAD / HTTP/1.1\r\nRange: bytes=2-5\r\n\r\n", nil)
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "4", headers["content-length"])
+	assert.Empty(t, body)
+}
+
+func TestServeContentIfRange(t *testing.T) {
+	withETag := func(w *response.Writer) { w.Header().Set("ETag", `"v1"`) }
+	lastModified := modTime.Format(headers.TimeFormat)
+
+	tests := []struct {
+		ifRange string
+		setup   func(w *response.Writer)
+		partial bool
+	}{
+		{lastModified, nil, true},
+		{modTime.Add(-time.Hour).Format(headers.TimeFormat), nil, false}, // changed since
+		{`"v1"`, withETag, true},
+		{`"v0"`, withETag, false},
+		{`W/"v1"`, withETag, false}, // weak validators never match
+		{`"v1"`, nil, false},
+		{"garbage", nil, false},
+	}
+	for _, tt := range tests {
+		status, _, _ := serveContent(t, "GET / HTTP/1.1\r\nRange: bytes=0-0\r\nIf-Range: "+tt.ifRange+"\r\n\r\n", tt.setup)
+		if tt.partial {
+			assert.Equal(t, "HTTP/1.1 206 Partial Content", status, tt.ifRange)
+		} else {
+			assert.Equal(t, "HTTP/1.1 200 OK", status, tt.ifRange)
+		}
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	h := New(testFS, Options{})
+
+	status, headers, body := get(t, h, "GET /app.js HTTP/1.1\r\nRange: bytes=-3\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "bytes 11-13/14", headers["content-range"])
+	assert.Equal(t, "text/javascript; charset=utf-8", headers["content-type"])
+	assert.Equal(t, "(1)", body)
+}
+
+func TestFileServerConditional(t *testing.T) {
+	h := New(testFS, Options{})
+
+	_, headers, _ := get(t, h, "GET / HTTP/1.1\r\n\r\n")
+	etag := headers["etag"]
+	assert.Equal(t, `"17cb5b99f8638000-d"`, etag)
+
+	// Test: Same size, same second, another tag
+	assert.NotEqual(t, fileETag(modTime, 13), fileETag(modTime.Add(time.Millisecond), 13))
+
+	// Test: Revalidation by tag or by date
+	status, headers, body := get(t, h, "GET / HTTP/1.1\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", status)
+	assert.Equal(t, etag, headers["etag"])
+	assert.Empty(t, body)
+	status, _, _ = get(t, h, "GET / HTTP/1.1\r\nIf-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", status)
+	status, _, _ = get(t, h, "GET / HTTP/1.1\r\nIf-Unmodified-Since: Tue, 30 Apr 2024 12:00:00 GMT\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", status)
+
+	// Test: If-Range with the file's tag
+	status, _, body = get(t, h, "GET / HTTP/1.1\r\nRange: bytes=0-3\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 206 Partial Content", status)
+	assert.Equal(t, "<h1>", body)
+}
@@ -0,0 +1,135 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file DetectContentType looks at, same as the WHATWG algorithm.
+const sniffLen = 512
+
+// The types the frontend builds need, checked before the mime package (whose table depends on the OS).
+var extensionTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".xml":   "text/xml; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".wasm":  "application/wasm",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+}
+
+// ContentTypeByExtension is the type for the file name, empty when the extension is unknown.
+func ContentTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if ct, ok := extensionTypes[ext]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// signature is a magic number at the start of a file.
+type signature struct {
+	prefix      []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{[]byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{[]byte("\xff\xd8\xff"), "image/jpeg"},
+	{[]byte("GIF87a"), "image/gif"},
+	{[]byte("GIF89a"), "image/gif"},
+	{[]byte("%PDF-"), "application/pdf"},
+	{[]byte("\x1f\x8b\x08"), "application/gzip"},
+	{[]byte("PK\x03\x04"), "application/zip"},
+	{[]byte("\x00asm"), "application/wasm"},
+	{[]byte("wOFF"), "font/woff"},
+	{[]byte("wOF2"), "font/woff2"},
+	{[]byte("\x1a\x45\xdf\xa3"), "video/webm"},
+	{[]byte("ID3"), "audio/mpeg"},
+}
+
+// Lowercase html tags that make a text file html, the way browsers sniff it.
+var htmlPrefixes = []string{"<!doctype html", "<html", "<head", "<body", "<script", "<iframe", "<h1", "<div", "<p", "<table", "<title", "<style", "<!--"}
+
+// DetectContentType guesses the type from the first bytes of a file (up to 512 are looked at):
+// known magic numbers first, then html, then text if it is valid utf-8 without control characters.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, sig.prefix) {
+			return sig.contentType
+		}
+	}
+	// RIFF containers, only webp is interesting here
+	if len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+
+	text := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := strings.ToLower(string(text[:min(len(text), 16)]))
+	for _, prefix := range htmlPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			// the tag must end right after the name: "<p>" or "<p class" is html, "<pre" isn't "<p"
+			if len(lower) == len(prefix) || prefix == "<!--" || lower[len(prefix)] == ' ' || lower[len(prefix)] == '>' {
+				return "text/html; charset=utf-8"
+			}
+		}
+	}
+	if strings.HasPrefix(lower, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText refuses control characters other than whitespace, a truncated rune at the end is fine.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\x0c' {
+			return false
+		}
+		if r == 0x7f {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
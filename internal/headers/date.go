@@ -0,0 +1,26 @@
+package headers
+
+import "time"
+
+// TimeFormat is the HTTP date format (IMF-fixdate), times must be in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// The obsolete formats recipients still have to accept (RFC 9110 section 5.6.7).
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT", // RFC 850
+	"Mon Jan _2 15:04:05 2006",       // asctime
+}
+
+// ParseTime reads an HTTP date, in any of the three formats.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range obsoleteTimeFormats {
+		if t, err2 := time.Parse(layout, value); err2 == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
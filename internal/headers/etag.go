@@ -0,0 +1,69 @@
+package headers
+
+import "strings"
+
+// ETag is a parsed entity tag, W/"abc" is {Opaque: "abc", Weak: true}.
+type ETag struct {
+	Opaque string
+	Weak   bool
+}
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Opaque + `"`
+	}
+	return `"` + e.Opaque + `"`
+}
+
+// ParseETag reads a single entity tag, false if it isn't one.
+func ParseETag(value string) (ETag, bool) {
+	tags, ok := ParseETagList(value)
+	if !ok || len(tags) != 1 {
+		return ETag{}, false
+	}
+	return tags[0], true
+}
+
+// ParseETagList reads the comma separated entity tags of If-Match / If-None-Match.
+// The "*" form is not handled here, callers check for it first.
+func ParseETagList(value string) ([]ETag, bool) {
+	var tags []ETag
+	s := value
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags, len(tags) > 0
+		}
+
+		var tag ETag
+		if strings.HasPrefix(s, "W/") {
+			tag.Weak = true
+			s = s[2:]
+		}
+		if !strings.HasPrefix(s, `"`) {
+			return nil, false
+		}
+		// The opaque part can hold commas, but never a quote.
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return nil, false
+		}
+		tag.Opaque = s[1 : end+1]
+		tags = append(tags, tag)
+
+		s = strings.TrimLeft(s[end+2:], " \t")
+		if s != "" && s[0] != ',' {
+			return nil, false
+		}
+	}
+}
+
+// StrongMatch is the comparison of If-Match: both strong and the same.
+func (e ETag) StrongMatch(other ETag) bool {
+	return !e.Weak && !other.Weak && e.Opaque == other.Opaque
+}
+
+// WeakMatch is the comparison of If-None-Match: the same, weak or not.
+func (e ETag) WeakMatch(other ETag) bool {
+	return e.Opaque == other.Opaque
+}
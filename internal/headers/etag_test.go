@@ -0,0 +1,45 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseETagList(t *testing.T) {
+	tags, ok := ParseETagList(`"a", W/"b" ,"c,d",`)
+	require.True(t, ok)
+	assert.Equal(t, []ETag{{Opaque: "a"}, {Opaque: "b", Weak: true}, {Opaque: "c,d"}}, tags)
+	assert.Equal(t, `W/"b"`, tags[1].String())
+
+	for _, bad := range []string{``, `a`, `"a`, `"a" "b"`, `w/"a"`, `*`} {
+		_, ok := ParseETagList(bad)
+		assert.False(t, ok, bad)
+	}
+
+	// Test: Strong vs weak comparison (RFC 9110 section 8.8.3.2)
+	strong, weak := ETag{Opaque: "1"}, ETag{Opaque: "1", Weak: true}
+	assert.True(t, strong.StrongMatch(strong))
+	assert.False(t, strong.StrongMatch(weak))
+	assert.False(t, weak.StrongMatch(weak))
+	assert.True(t, weak.WeakMatch(strong))
+	assert.False(t, weak.WeakMatch(ETag{Opaque: "2"}))
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	_, err := ParseTime("yesterday")
+	assert.Error(t, err)
+}
@@ -71,7 +71,10 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	keyStr := string(normalizedKey)
 	valStr := string(value)
 
-	if existingValue, ok := h[keyStr]; ok {
+	if existingValue, ok := h[keyStr]; ok && keyStr == "cookie" {
+		// Cookie pairs are separated with "; ", a comma may be part of a (sloppy) value (RFC 9113 section 8.2.3)
+		h[keyStr] = existingValue + "; " + valStr
+	} else if ok {
 		// RFC 9110: Append with comma
 		h[keyStr] = existingValue + ", " + valStr // Believe it or not, but it is allowed to have more than one value for the same key ;D.
 	} else {
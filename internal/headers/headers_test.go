@@ -108,4 +108,9 @@ func TestRequestHeaders(t *testing.T) {
 	
 	expected2 := "lane-loves-go, prime-loves-zig, tj-loves-ocaml"
 	assert.Equal(t, expected2, h["set-person"])
-}
\ No newline at end of file
+
+	// 5. Cookie lines are joined like cookie pairs, not with a comma
+	h.Parse([]byte("Cookie: a=1\r\n"))
+	h.Parse([]byte("Cookie: b=2\r\n"))
+	assert.Equal(t, "a=1; b=2", h["cookie"])
+}
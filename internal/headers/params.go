@@ -0,0 +1,137 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ErrMalformedAuthParams = fmt.Errorf("malformed header: bad auth-param list")
+
+// AuthChallenge is one challenge of WWW-Authenticate, or the credentials of Authorization:
+// the scheme and its auth-params, names lowercased.
+type AuthChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// ParseAuthChallenges reads a WWW-Authenticate (or Authorization) value, which may hold several
+// challenges: `Basic realm="a", Digest realm="b", nonce="n"` (RFC 9110 section 11.2).
+// The token68 form (`Basic dXNlcg==`) isn't handled, only auth-params.
+func ParseAuthChallenges(value string) ([]AuthChallenge, error) {
+	var challenges []AuthChallenge
+	p := &paramLexer{s: value}
+
+	for {
+		p.skip(" \t,")
+		if p.done() {
+			return challenges, nil
+		}
+		name := p.token()
+		if name == "" {
+			return nil, ErrMalformedAuthParams
+		}
+		p.skip(" \t")
+
+		if !p.consume('=') {
+			// Not a param, so the scheme of the next challenge
+			challenges = append(challenges, AuthChallenge{Scheme: name, Params: map[string]string{}})
+			continue
+		}
+		if len(challenges) == 0 {
+			return nil, ErrMalformedAuthParams // a param before any scheme
+		}
+		value, ok := p.value()
+		if !ok {
+			return nil, ErrMalformedAuthParams
+		}
+		challenges[len(challenges)-1].Params[strings.ToLower(name)] = value
+
+		p.skip(" \t")
+		if !p.done() && p.peek() != ',' {
+			return nil, ErrMalformedAuthParams
+		}
+	}
+}
+
+// ParseAuthParams reads a bare list of auth-params, `a=b, c="d"`.
+func ParseAuthParams(value string) (map[string]string, error) {
+	challenges, err := ParseAuthChallenges("x " + value)
+	if err != nil {
+		return nil, err
+	}
+	if len(challenges) != 1 {
+		return nil, ErrMalformedAuthParams
+	}
+	return challenges[0].Params, nil
+}
+
+// QuoteString makes a quoted-string, escaping '"' and '\'.
+func QuoteString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+type paramLexer struct {
+	s   string
+	pos int
+}
+
+func (p *paramLexer) done() bool { return p.pos >= len(p.s) }
+func (p *paramLexer) peek() byte { return p.s[p.pos] }
+
+func (p *paramLexer) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.peek()) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *paramLexer) consume(c byte) bool {
+	if !p.done() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *paramLexer) token() string {
+	start := p.pos
+	// isTokenChar expects lowercased keys
+	for !p.done() && isTokenChar(toLower(p.peek())) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// value is a token or a quoted-string, with its escapes undone.
+func (p *paramLexer) value() (string, bool) {
+	p.skip(" \t")
+	if !p.consume('"') {
+		v := p.token()
+		return v, v != ""
+	}
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), true
+		case '\\':
+			if p.done() {
+				return "", false
+			}
+			b.WriteByte(p.peek())
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false // unterminated
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,37 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAuthChallenges(t *testing.T) {
+	// Test: Several challenges, quoted and token values, escapes, commas inside quotes
+	challenges, err := ParseAuthChallenges(`Basic realm="admin, \"eu\"", Digest Realm="admin", qop="auth, auth-int", algorithm=SHA-256, nonce="abc=="`)
+	require.NoError(t, err)
+	require.Len(t, challenges, 2)
+	assert.Equal(t, "Basic", challenges[0].Scheme)
+	assert.Equal(t, map[string]string{"realm": `admin, "eu"`}, challenges[0].Params)
+	assert.Equal(t, "Digest", challenges[1].Scheme)
+	assert.Equal(t, map[string]string{"realm": "admin", "qop": "auth, auth-int", "algorithm": "SHA-256", "nonce": "abc=="}, challenges[1].Params)
+
+	// Test: A scheme without params
+	challenges, err = ParseAuthChallenges("Negotiate, Basic realm=x")
+	require.NoError(t, err)
+	require.Len(t, challenges, 2)
+	assert.Empty(t, challenges[0].Params)
+
+	// Test: Broken lists
+	for _, bad := range []string{`realm="x"`, `Digest realm="x`, `Digest realm=`, `Digest realm="x" nonce="y"`, `Digest a=b c`} {
+		_, err := ParseAuthChallenges(bad)
+		assert.ErrorIs(t, err, ErrMalformedAuthParams, bad)
+	}
+
+	params, err := ParseAuthParams(`rspauth="abc", qop=auth, nc=00000001`)
+	require.NoError(t, err)
+	assert.Equal(t, "00000001", params["nc"])
+
+	assert.Equal(t, `"a \"b\" \\c"`, QuoteString(`a "b" \c`))
+}
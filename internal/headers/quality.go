@@ -0,0 +1,47 @@
+package headers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QualityValue is one entry of a list like Accept-Encoding: "gzip;q=0.8".
+type QualityValue struct {
+	Value string
+	Q     float64
+}
+
+// ParseQualityList reads a comma separated list with optional q weights, sorted by q (highest first,
+// the order of the header on ties). A missing q is 1, a broken one makes the entry ignored.
+func ParseQualityList(value string) []QualityValue {
+	var list []QualityValue
+	for _, part := range strings.Split(value, ",") {
+		item, params, _ := strings.Cut(part, ";")
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		qv := QualityValue{Value: item, Q: 1}
+		valid := true
+		for _, param := range strings.Split(params, ";") {
+			name, v, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			qv.Q = q
+		}
+		if valid {
+			list = append(list, qv)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Q > list[j].Q })
+	return list
+}
@@ -0,0 +1,19 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQualityList(t *testing.T) {
+	list := ParseQualityList("deflate;q=0.5, GZIP , br;q=0.8, *;q=0, x;q=2, y;q=abc,")
+	assert.Equal(t, []QualityValue{
+		{Value: "gzip", Q: 1},
+		{Value: "br", Q: 0.8},
+		{Value: "deflate", Q: 0.5},
+		{Value: "*", Q: 0},
+	}, list)
+
+	assert.Empty(t, ParseQualityList(""))
+}
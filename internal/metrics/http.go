@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// Handler renders the registry for a Prometheus scrape.
+func Handler(reg *Registry) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.BufferBody()
+		w.WriteHeader(response.StatusOK)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.WriteText(w)
+	}
+}
+
+// HTTPMetrics is the standard set of server metrics, fed by Middleware, ConnState and ErrorHandler.
+type HTTPMetrics struct {
+	// Route groups the requests for the route label, "other" for all of them by default.
+	// Whatever it returns must come from a fixed set (see RouteByPaths, map /users/42 -> /users/:id):
+	// every distinct value is a new series kept forever, clients must not be able to make them up.
+	Route func(req *request.Request) string
+
+	requests          *Counter
+	duration          *Histogram
+	bytesIn           *Counter
+	bytesOut          *Counter
+	activeConnections *Gauge
+	parseErrors       *Counter
+}
+
+func NewHTTPMetrics(reg *Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		Route: RouteOther,
+
+		requests:          reg.NewCounter("http_requests_total", "Requests handled, by method, route and status.", "method", "route", "status"),
+		duration:          reg.NewHistogram("http_request_duration_seconds", "Time spent in the handler.", DefaultBuckets, "method", "route"),
+		bytesIn:           reg.NewCounter("http_request_bytes_total", "Request body bytes received.", "method", "route"),
+		bytesOut:          reg.NewCounter("http_response_bytes_total", "Response body bytes written by the handlers, before compression.", "method", "route"),
+		activeConnections: reg.NewGauge("http_active_connections", "Connections currently open."),
+		parseErrors:       reg.NewCounter("http_parse_errors_total", "Requests that could not be parsed, by kind.", "kind"),
+	}
+}
+
+// RoutePath is the request target without the query string. Not a Route on its own,
+// any scanner hitting random urls would make a series each.
+func RoutePath(req *request.Request) string {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return path
+}
+
+// RouteOther puts every request in the same "other" route.
+func RouteOther(req *request.Request) string {
+	return "other"
+}
+
+// RouteByPaths keeps the known paths as their own route, anything else is "other".
+func RouteByPaths(paths ...string) func(req *request.Request) string {
+	known := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		known[p] = true
+	}
+	return func(req *request.Request) string {
+		if p := RoutePath(req); known[p] {
+			return p
+		}
+		return "other"
+	}
+}
+
+// methodLabel is the method for the labels, folded to the standard ones (RFC 9110 section 9)
+// since the parser takes any token.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// Middleware records the request count, duration and body sizes.
+// The response bytes are the handler's, counted before compression or any other body filter.
+func (m *HTTPMetrics) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			finished := false
+			// Deferred so a panic is counted too, it goes on to the server untouched.
+			defer func() {
+				status := w.StatusCode()
+				if !finished && !w.Committed() && !w.Hijacked() {
+					status = response.StatusInternalServerError // what the server answers once it recovers
+				}
+				method := methodLabel(req.RequestLine.Method)
+				route := m.Route(req)
+
+				m.requests.Inc(method, route, strconv.Itoa(int(status)))
+				m.duration.Observe(time.Since(start).Seconds(), method, route)
+				m.bytesIn.Add(float64(req.BodyBytes()), method, route)
+				m.bytesOut.Add(float64(w.BytesWritten()), method, route)
+			}()
+
+			next(w, req)
+			finished = true
+		}
+	}
+}
+
+// ConnState tracks the open connections, plug it into server.Config.ConnState.
+func (m *HTTPMetrics) ConnState(conn net.Conn, state server.ConnState) {
+	switch state {
+	case server.StateNew:
+		m.activeConnections.Inc()
+	case server.StateHijacked, server.StateClosed:
+		m.activeConnections.Dec()
+	}
+}
+
+// ErrorHandler counts the parse errors before handing them to next (server.DefaultErrorHandler when nil),
+// plug it into server.Config.ErrorHandler.
+func (m *HTTPMetrics) ErrorHandler(next func(w *response.Writer, err error)) func(w *response.Writer, err error) {
+	if next == nil {
+		next = server.DefaultErrorHandler
+	}
+	return func(w *response.Writer, err error) {
+		m.parseErrors.Inc(ErrorKind(err))
+		next(w, err)
+	}
+}
+
+// ErrorKind names the kind of a parse error returned by the request package.
+func ErrorKind(err error) string {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, request.ERROR_PARSING_METHOD_IN_REQUEST_LINE), errors.Is(err, request.ERROR_INVALID_METHOD):
+		return "method"
+	case errors.Is(err, request.ERROR_PARSING_TARGET_IN_REQUEST_LINE):
+		return "target"
+	case errors.Is(err, request.ERROR_PARSING_HTTP_VERSION_IN_REQUEST_LINE), errors.Is(err, request.ERROR_UNSUPPORTED_VERSION):
+		return "version"
+	case errors.Is(err, headers.ErrNoColon), errors.Is(err, headers.ErrSpaceBeforeColon),
+		errors.Is(err, headers.ErrEmptyKey), errors.Is(err, headers.ErrInvalidCharInKey):
+		return "header"
+	case errors.Is(err, request.ERROR_PARSING_BODY_INVALID_CONTENT_LENGTH):
+		return "body_length"
+	case errors.Is(err, request.ERROR_HEADERS_TOO_LARGE):
+		return "headers_too_large"
+	case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+		return "body_too_large"
+	case errors.Is(err, request.ERROR_UNEXPECTED_EOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The three kinds of metric we need, rendered in the Prometheus text exposition format (version 0.0.4).
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets are the histogram buckets for request durations, in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds every metric family and renders them.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is one metric name with all its label combinations (series).
+type family struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series // key: label values joined with \xff
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counter and gauge
+	counts      []uint64 // histogram, one per bucket (non cumulative)
+	sum         float64
+	count       uint64
+}
+
+// Counter only goes up.
+type Counter struct{ f *family }
+
+// Gauge goes up and down.
+type Gauge struct{ f *family }
+
+// Histogram counts observations into buckets.
+type Histogram struct{ f *family }
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, labelNames, nil)}
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, labelNames, nil)}
+}
+
+// NewHistogram uses DefaultBuckets when buckets is nil.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, typeHistogram, labelNames, buckets)}
+}
+
+// register panics on a duplicate name, that is a programming error caught at startup.
+func (r *Registry) register(name, help, typ string, labelNames []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.families[name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series for the label values, creating it on first use. Caller holds f.mu.
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add panics on negative values, a counter never goes down.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.with(labelValues).value += v
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.with(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.with(labelValues)
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// WriteText renders every family, sorted by name, in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) writeText(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+		labels := formatLabels(f.labelNames, s.labelValues)
+
+		if f.typ != typeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, wrapLabels(labels), formatValue(s.value))
+			continue
+		}
+
+		// Buckets are cumulative on the wire, and there is always a +Inf one equal to the count.
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i]
+			le := `le="` + formatValue(upper) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, wrapLabels(labels), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Label values escape backslash, double quote and line feed.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// HELP lines escape backslash and line feed only.
+func escapeHelp(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v)
+}
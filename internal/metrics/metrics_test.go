@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func render(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var out bytes.Buffer
+	require.NoError(t, reg.WriteText(&out))
+	return out.String()
+}
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("jobs_total", "Jobs done.", "queue")
+	g := reg.NewGauge("workers", "Busy workers.")
+	h := reg.NewHistogram("latency_seconds", "Job latency.", []float64{0.1, 1})
+
+	c.Inc("default")
+	c.Add(2, `we"ird\`)
+	g.Set(3)
+	g.Dec()
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(7)
+
+	out := render(t, reg)
+
+	// Test: families sorted by name, counters with escaped labels
+	assert.Equal(t, `# HELP jobs_total Jobs done.
+# TYPE jobs_total counter
+jobs_total{queue="default"} 1
+jobs_total{queue="we\"ird\\"} 2
+# HELP latency_seconds Job latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 7.55
+latency_seconds_count 3
+# HELP workers Busy workers.
+# TYPE workers gauge
+workers 2
+`, out)
+
+	// Test: duplicate names and wrong label counts are programming errors
+	assert.Panics(t, func() { reg.NewGauge("workers", "again") })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "default") })
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	reg := NewRegistry()
+	m := NewHTTPMetrics(reg)
+	// Test: Out of the box, every request is in one route
+	anyReq, err := request.RequestFromReader(strings.NewReader("GET /users/42 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "other", m.Route(anyReq))
+	m.Route = RouteByPaths("/hello", "/missing")
+
+	h := m.Middleware()(func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/missing":
+			response.Error(w, response.StatusBadRequest, "nope")
+			return
+		case "/boom":
+			panic("boom")
+		}
+		w.WriteBody([]byte("hello"))
+	})
+
+	// Test: A panic is counted as the 500 the server answers, and still reaches the server
+	boom, err := request.RequestFromReader(strings.NewReader("GET /boom HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.PanicsWithValue(t, "boom", func() { h(response.NewWriter(&bytes.Buffer{}), boom) })
+
+	for _, raw := range []string{
+		"GET /hello?x=1 HTTP/1.1\r\n\r\n",
+		"GET /hello HTTP/1.1\r\n\r\n",
+		"POST /missing HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc",
+		"GET /wp-login.php HTTP/1.1\r\n\r\n",
+		"GET /.env HTTP/1.1\r\n\r\n",
+		"BREW /hello HTTP/1.1\r\n\r\n",
+	} {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		w := response.NewWriter(&bytes.Buffer{})
+		h(w, req)
+		require.NoError(t, w.Flush())
+	}
+
+	m.ConnState(nil, server.StateNew)
+	m.ConnState(nil, server.StateNew)
+	m.ConnState(nil, server.StateClosed)
+
+	var handled []error
+	eh := m.ErrorHandler(func(w *response.Writer, err error) { handled = append(handled, err) })
+	for _, raw := range []string{"get / HTTP/1.1\r\n\r\n", "GET / HTTP/2.0\r\n\r\n", "GET / HTTP/1.1\r\nbad header\r\n\r\n"} {
+		_, err := request.RequestFromReader(strings.NewReader(raw))
+		require.Error(t, err)
+		eh(response.NewWriter(&bytes.Buffer{}), err)
+	}
+	eh(nil, fmt.Errorf("something else"))
+	assert.Len(t, handled, 4)
+
+	out := render(t, reg)
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/hello",status="200"} 2`)
+	assert.Contains(t, out, `http_requests_total{method="POST",route="/missing",status="400"} 1`)
+	// unknown paths and methods share a series
+	assert.Contains(t, out, `http_requests_total{method="GET",route="other",status="200"} 2`)
+	assert.Contains(t, out, `http_requests_total{method="OTHER",route="/hello",status="200"} 1`)
+	assert.Contains(t, out, `http_requests_total{method="GET",route="other",status="500"} 1`)
+	assert.NotContains(t, out, "wp-login")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/hello"} 2`)
+	assert.Contains(t, out, `http_request_bytes_total{method="POST",route="/missing"} 3`)
+	assert.Contains(t, out, `http_response_bytes_total{method="GET",route="/hello"} 10`)
+	assert.Contains(t, out, "http_active_connections 1\n")
+	assert.Contains(t, out, `http_parse_errors_total{kind="method"} 1`)
+	assert.Contains(t, out, `http_parse_errors_total{kind="version"} 1`)
+	assert.Contains(t, out, `http_parse_errors_total{kind="header"} 1`)
+	assert.Contains(t, out, `http_parse_errors_total{kind="other"} 1`)
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("up_total", "Ups.").Inc()
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /metrics HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	Handler(reg)(w, req)
+	require.NoError(t, w.Flush())
+
+	body := "# HELP up_total Ups.\n# TYPE up_total counter\nup_total 1\n"
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out.String(), "content-type: text/plain; version=0.0.4; charset=utf-8\r\n")
+	assert.Contains(t, out.String(), fmt.Sprintf("content-length: %d\r\n", len(body)))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"+body))
+}
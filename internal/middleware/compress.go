@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// CompressConfig tunes the Compress middleware.
+type CompressConfig struct {
+	// Level is the compress/flate level, flate.DefaultCompression when zero.
+	Level int
+	// MinSize is the smallest body worth compressing, 1024 bytes when zero.
+	// Streamed bodies without a Content-Length are always compressed.
+	MinSize int
+	// SkipTypes are Content-Type prefixes that are already compressed, DefaultSkipTypes when nil.
+	SkipTypes []string
+}
+
+// DefaultSkipTypes are the media types compressing again would only burn CPU for.
+var DefaultSkipTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"video/", "audio/",
+	"font/woff", // and woff2
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/pdf", "application/octet-stream",
+}
+
+// The encodings we speak, in order of preference on equal q.
+var encodings = []string{"gzip", "deflate"}
+
+// Compress negotiates gzip or deflate with Accept-Encoding and compresses the response body.
+// Buffered bodies get their compressed Content-Length, streamed ones switch to chunked.
+func Compress(cfg CompressConfig) server.Middleware {
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 1024
+	}
+	if cfg.SkipTypes == nil {
+		cfg.SkipTypes = DefaultSkipTypes
+	}
+	pools := map[string]*sync.Pool{
+		"gzip":    {New: func() any { gz, _ := gzip.NewWriterLevel(nil, cfg.Level); return gz }},
+		"deflate": {New: func() any { zw, _ := zlib.NewWriterLevel(nil, cfg.Level); return zw }},
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			accept, _ := req.Headers.Get([]byte("Accept-Encoding"))
+			encoding := NegotiateEncoding(accept)
+			if req.RequestLine.Method == "HEAD" {
+				encoding = ""
+			}
+
+			w.OnCommit(func() {
+				// Whatever we end up sending, caches must key this url on Accept-Encoding.
+				// Added at commit time so a handler setting its own Vary doesn't drop it.
+				addVary(w.Header(), "Accept-Encoding")
+
+				if encoding == "" || !shouldCompress(w, cfg) {
+					return
+				}
+
+				h := w.Header()
+				h.Set("Content-Encoding", encoding)
+				delete(h, "content-length")
+				if !w.Buffered() {
+					h.Set("Transfer-Encoding", "chunked")
+				}
+				// Same content, other bytes: a strong tag would lie (RFC 9110 section 8.8.1).
+				if etag, err := h.Get([]byte("ETag")); err == nil && strings.HasPrefix(etag, `"`) {
+					h.Set("ETag", "W/"+etag)
+				}
+
+				pool := pools[encoding]
+				w.WrapBody(func(dst io.Writer) io.WriteCloser {
+					return newPooledCompressor(pool, dst)
+				})
+			})
+
+			next(w, req)
+		}
+	}
+}
+
+// NegotiateEncoding picks gzip or deflate from an Accept-Encoding value, empty for identity.
+func NegotiateEncoding(accept string) string {
+	list := headers.ParseQualityList(accept)
+
+	best, bestQ := "", 0.0
+	for _, enc := range encodings {
+		q, listed := 0.0, false
+		for _, qv := range list {
+			if qv.Value == enc {
+				q, listed = qv.Q, true
+				break
+			}
+		}
+		// "*" stands for every coding not listed by name
+		if !listed {
+			for _, qv := range list {
+				if qv.Value == "*" {
+					q = qv.Q
+					break
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// shouldCompress looks at the response about to be committed.
+func shouldCompress(w *response.Writer, cfg CompressConfig) bool {
+	status := w.StatusCode()
+	if status < 200 || status == response.StatusNoContent || status == response.StatusNotModified || status == response.StatusPartialContent {
+		return false
+	}
+
+	h := w.Header()
+	if _, err := h.Get([]byte("Content-Encoding")); err == nil {
+		return false // already encoded by the handler
+	}
+	if _, err := h.Get([]byte("Content-Range")); err == nil {
+		return false
+	}
+
+	contentType, _ := h.Get([]byte("Content-Type"))
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range cfg.SkipTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	size := -1
+	if w.Buffered() {
+		size = len(w.BufferedBody())
+	} else if cl, err := h.Get([]byte("Content-Length")); err == nil {
+		if n, err := strconv.Atoi(cl); err == nil {
+			size = n
+		}
+	}
+	return size < 0 || size >= cfg.MinSize
+}
+
+// addVary appends to Vary instead of replacing what is already there.
+func addVary(h headers.Headers, name string) {
+	vary, err := h.Get([]byte("Vary"))
+	if err != nil || vary == "" {
+		h.Set("Vary", name)
+		return
+	}
+	for _, v := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) || strings.TrimSpace(v) == "*" {
+			return
+		}
+	}
+	h.Set("Vary", vary+", "+name)
+}
+
+// compressor is what gzip.Writer and zlib.Writer have in common.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledCompressor gives its compressor back to the pool once the body is done,
+// allocating a gzip.Writer per response is most of the cost otherwise.
+type pooledCompressor struct {
+	compressor
+	pool *sync.Pool
+}
+
+// In HTTP "deflate" is the zlib format (RFC 9110 section 8.4.1.2), so the zlib writer
+// (compress/flate plus a small header and checksum) is what goes out for it.
+func newPooledCompressor(pool *sync.Pool, dst io.Writer) *pooledCompressor {
+	c := pool.Get().(compressor)
+	c.Reset(dst)
+	return &pooledCompressor{compressor: c, pool: pool}
+}
+
+func (p *pooledCompressor) Close() error {
+	if p.compressor == nil {
+		return nil
+	}
+	err := p.compressor.Close()
+	p.pool.Put(p.compressor)
+	p.compressor = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var bigJSON = `{"items":[` + strings.Repeat(`{"name":"widget","price":42},`, 200) + `{}]}`
+
+// splitResponse returns the lowercase headers and the raw body of a response.
+func splitResponse(t *testing.T, out string) (map[string]string, string) {
+	t.Helper()
+	head, body, ok := strings.Cut(out, "\r\n\r\n")
+	require.True(t, ok)
+	headers := map[string]string{}
+	for _, line := range strings.Split(head, "\r\n")[1:] {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+	return headers, body
+}
+
+// unchunk decodes a chunked body and checks it is properly terminated.
+func unchunk(t *testing.T, body string) string {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(body))
+	var out bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			rest, _ := io.ReadAll(r)
+			require.Equal(t, "\r\n", string(rest))
+			return out.String()
+		}
+		_, err = io.CopyN(&out, r, size)
+		require.NoError(t, err)
+		crlf := make([]byte, 2)
+		_, err = io.ReadFull(r, crlf)
+		require.NoError(t, err)
+		require.Equal(t, "\r\n", string(crlf))
+	}
+}
+
+func gunzip(t *testing.T, body string) string {
+	t.Helper()
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompress(t *testing.T) {
+	buffered := func(contentType, body string) func(w *response.Writer, req *request.Request) {
+		return func(w *response.Writer, req *request.Request) {
+			w.BufferBody()
+			w.Header().Set("Content-Type", contentType)
+			w.WriteBody([]byte(body))
+		}
+	}
+	h := Compress(CompressConfig{})(buffered("application/json", bigJSON))
+
+	// Test: Buffered body, gzip with the compressed length
+	headers, body := splitResponse(t, run(t, h, "GET / HTTP/1.1\r\nAccept-Encoding: gzip, deflate\r\n\r\n"))
+	assert.Equal(t, "gzip", headers["content-encoding"])
+	assert.Equal(t, "Accept-Encoding", headers["vary"])
+	assert.Equal(t, strconv.Itoa(len(body)), headers["content-length"])
+	assert.Less(t, len(body)*10, len(bigJSON))
+	assert.Equal(t, bigJSON, gunzip(t, body))
+
+	// Test: deflate is the zlib format
+	headers, body = splitResponse(t, run(t, h, "GET / HTTP/1.1\r\nAccept-Encoding: gzip;q=0.5, deflate\r\n\r\n"))
+	assert.Equal(t, "deflate", headers["content-encoding"])
+	zr, err := zlib.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, bigJSON, string(data))
+
+	// Test: Streamed body switches to chunked, the Content-Length is dropped
+	streamed := Compress(CompressConfig{})(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(bigJSON)))
+		w.WriteBody([]byte(bigJSON))
+		w.Flush()
+		w.WriteBody([]byte(bigJSON))
+	})
+	headers, body = splitResponse(t, run(t, streamed, "GET / HTTP/1.1\r\nAccept-Encoding: *\r\n\r\n"))
+	assert.Equal(t, "gzip", headers["content-encoding"])
+	assert.Equal(t, "chunked", headers["transfer-encoding"])
+	assert.NotContains(t, headers, "content-length")
+	assert.Equal(t, bigJSON+bigJSON, gunzip(t, unchunk(t, body)))
+
+	// Test: Left alone: tiny bodies, compressed types, identity only, HEAD, errors without body
+	for _, tc := range []struct {
+		handler func(w *response.Writer, req *request.Request)
+		raw     string
+	}{
+		{buffered("application/json", `{"ok":true}`), "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"},
+		{buffered("image/png", bigJSON), "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"},
+		{buffered("text/plain", bigJSON), "GET / HTTP/1.1\r\n\r\n"},
+		{buffered("text/plain", bigJSON), "GET / HTTP/1.1\r\nAccept-Encoding: gzip;q=0, identity\r\n\r\n"},
+		{buffered("text/plain", bigJSON), "GET / HTTP/1.1\r\nAccept-Encoding: br\r\n\r\n"},
+		{buffered("text/plain", bigJSON), "HEAD / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"},
+	} {
+		headers, _ = splitResponse(t, run(t, Compress(CompressConfig{})(tc.handler), tc.raw))
+		assert.NotContains(t, headers, "content-encoding", tc.raw)
+		assert.Equal(t, "Accept-Encoding", headers["vary"], tc.raw)
+	}
+}
+
+func TestCompressWithETag(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Vary", "Origin")
+		w.WriteBody([]byte(bigJSON))
+	}
+	h := Compress(CompressConfig{})(ETag(ETagConfig{})(handler))
+	etag := response.StrongETag([]byte(bigJSON))
+
+	// Test: The tag of the uncompressed body is weakened once compressed
+	headers, body := splitResponse(t, run(t, h, "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"))
+	assert.Equal(t, "W/"+etag, headers["etag"])
+	assert.Equal(t, "Origin, Accept-Encoding", headers["vary"])
+	assert.Equal(t, bigJSON, gunzip(t, body))
+
+	// Test: A 304 stays empty, nothing to compress
+	out := run(t, h, "GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\nIf-None-Match: W/"+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, out, "content-encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"deflate, gzip":             "gzip", // tie, our preference
+		"gzip;q=0.2, deflate;q=0.9": "deflate",
+		"*":                         "gzip",
+		"*;q=0.1, gzip;q=0":         "deflate",
+		"identity":                  "",
+		"br, zstd":                  "",
+		"GZip;Q=1":                  "gzip",
+	}
+	for accept, want := range tests {
+		assert.Equal(t, want, NegotiateEncoding(accept), accept)
+	}
+}
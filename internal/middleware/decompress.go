@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// DecompressConfig tunes the Decompress middleware.
+type DecompressConfig struct {
+	// MaxBytes caps the decoded body, 10 MiB when zero. A few KB of gzip can inflate to
+	// gigabytes, the compressed size limit of the server says nothing about this one.
+	MaxBytes int64
+}
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content-encoding")
+	ErrDecodedTooLarge     = errors.New("decoded body too large")
+)
+
+// Decompress decodes gzip and deflate request bodies before the handler sees them.
+// The handler gets the plain bytes in req.Body, without Content-Encoding and with the
+// decoded Content-Length. Other encodings get a 415, bodies over MaxBytes a 413 and
+// broken streams a 400. A body left on the connection (req.BodyStream) is decoded as the
+// handler reads it, going over MaxBytes or a broken stream is then a read error.
+func Decompress(cfg DecompressConfig) server.Middleware {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 10 << 20
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			encoding, err := req.Headers.Get([]byte("Content-Encoding"))
+			if err != nil || (len(req.Body) == 0 && req.BodyStream == nil) {
+				next(w, req)
+				return
+			}
+
+			if req.BodyStream != nil {
+				decoded, err := DecodeReader(req.BodyStream, encoding)
+				if err != nil {
+					decodeError(w, err)
+					return
+				}
+				req.BodyStream = &maxDecodedReader{r: decoded, left: cfg.MaxBytes}
+				delete(req.Headers, "content-encoding")
+				delete(req.Headers, "content-length") // not known until the end
+				next(w, req)
+				return
+			}
+
+			body, err := DecodeBody(req.Body, encoding, cfg.MaxBytes)
+			if err != nil {
+				decodeError(w, err)
+				return
+			}
+
+			req.Body = body
+			delete(req.Headers, "content-encoding")
+			req.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+			next(w, req)
+		}
+	}
+}
+
+// decodeError answers a body that couldn't be decoded.
+func decodeError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, ErrUnsupportedEncoding):
+		// Tell the client what we would have taken (RFC 9110 section 15.5.16)
+		w.Header().Set("Accept-Encoding", strings.Join(encodings, ", "))
+		response.Error(w, response.StatusUnsupportedMediaType, "unsupported content-encoding\n")
+	case errors.Is(err, ErrDecodedTooLarge):
+		response.Error(w, response.StatusContentTooLarge, "decoded body too large\n")
+	default:
+		response.Error(w, response.StatusBadRequest, "invalid compressed body\n")
+	}
+}
+
+// DecodeBody undoes a Content-Encoding value. The codings are listed in the order they were
+// applied, so they are undone from the last one.
+func DecodeBody(body []byte, encoding string, maxBytes int64) ([]byte, error) {
+	r, err := DecodeReader(bytes.NewReader(body), encoding)
+	if err != nil {
+		return nil, err
+	}
+	// One byte past the limit tells "exactly maxBytes" from "more"
+	decoded, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxBytes {
+		return nil, ErrDecodedTooLarge
+	}
+	return decoded, nil
+}
+
+// DecodeReader is DecodeBody on the fly: the returned reader gives the decoded bytes of r.
+func DecodeReader(r io.Reader, encoding string) (io.Reader, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var err error
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		default:
+			return nil, ErrUnsupportedEncoding
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// maxDecodedReader fails with ErrDecodedTooLarge once more than left bytes were decoded.
+type maxDecodedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (m *maxDecodedReader) Read(p []byte) (int, error) {
+	if m.left < 0 {
+		return 0, ErrDecodedTooLarge
+	}
+	// One byte past the limit tells "exactly left" from "more"
+	if int64(len(p)) > m.left+1 {
+		p = p[:m.left+1]
+	}
+	n, err := m.r.Read(p)
+	m.left -= int64(n)
+	if m.left < 0 {
+		return n + int(m.left), ErrDecodedTooLarge
+	}
+	return n, err
+}
+
+// "deflate" should be zlib, but plenty of clients send a raw deflate stream.
+// A zlib stream starts with a CM of 8 and a header that is a multiple of 31 (RFC 1950).
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(2)
+	if len(head) == 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
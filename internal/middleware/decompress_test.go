@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compressed(t *testing.T, newWriter func(io.Writer) io.WriteCloser, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := newWriter(&buf)
+	_, err := io.WriteString(zw, data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func post(encoding, body string) string {
+	return "POST /batch HTTP/1.1\r\nContent-Encoding: " + encoding + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestDecompress(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		_, errEncoding := req.Headers.Get([]byte("Content-Encoding"))
+		cl, _ := req.Headers.Get([]byte("Content-Length"))
+		response.Error(w, response.StatusOK, cl+" "+strconv.FormatBool(errEncoding == nil)+" "+string(req.Body))
+	}
+	h := Decompress(DecompressConfig{MaxBytes: 64})(echo)
+	batch := `{"cpu":42,"mem":17}`
+
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zl := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	raw := func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }
+
+	// Test: gzip, zlib and raw deflate all come out plain, without Content-Encoding
+	for _, tc := range []struct {
+		encoding string
+		body     string
+	}{
+		{"gzip", compressed(t, gz, batch)},
+		{"x-gzip", compressed(t, gz, batch)},
+		{"deflate", compressed(t, zl, batch)},
+		{"deflate", compressed(t, raw, batch)},
+		{"gzip, deflate", compressed(t, zl, compressed(t, gz, batch))},
+	} {
+		out := run(t, h, post(tc.encoding, tc.body))
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), tc.encoding)
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\n19 false "+batch), tc.encoding)
+	}
+
+	// Test: Plain bodies go through untouched
+	out := run(t, h, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasSuffix(out, "5 false hello"))
+
+	// Test: Unknown encodings get a 415 with what we accept
+	out = run(t, h, post("br", "xxxx"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 415 Unsupported Media Type\r\n"))
+	assert.Contains(t, out, "accept-encoding: gzip, deflate\r\n")
+
+	// Test: A bomb stops at the limit
+	bomb := compressed(t, gz, strings.Repeat("a", 10000))
+	out = run(t, h, post("gzip", bomb))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Exactly the limit is fine
+	out = run(t, h, post("gzip", compressed(t, gz, strings.Repeat("a", 64))))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Garbage is a 400
+	out = run(t, h, post("gzip", "not gzip at all"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	truncated := compressed(t, gz, batch)
+	out = run(t, h, post("gzip", truncated[:len(truncated)-6]))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestDecompressStream(t *testing.T) {
+	var read string
+	var readErr error
+	h := Decompress(DecompressConfig{MaxBytes: 64})(func(w *response.Writer, req *request.Request) {
+		data, err := io.ReadAll(req.BodyStream)
+		read, readErr = string(data), err
+		_, errEncoding := req.Headers.Get([]byte("Content-Encoding"))
+		assert.Error(t, errEncoding)
+	})
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	stream := func(body string) func(*request.Request) {
+		return func(req *request.Request) { req.BodyStream = strings.NewReader(body) }
+	}
+
+	// Test: A body left on the connection is decoded as the handler reads it
+	run(t, h, "POST / HTTP/1.1\r\nContent-Encoding: gzip\r\n\r\n", stream(compressed(t, gz, "streamed")))
+	require.NoError(t, readErr)
+	assert.Equal(t, "streamed", read)
+
+	// Test: Past MaxBytes the read fails
+	run(t, h, "POST / HTTP/1.1\r\nContent-Encoding: gzip\r\n\r\n", stream(compressed(t, gz, strings.Repeat("a", 100))))
+	assert.ErrorIs(t, readErr, ErrDecodedTooLarge)
+	assert.Len(t, read, 64)
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// ETagConfig tunes the ETag middleware.
+type ETagConfig struct {
+	// Weak makes W/"..." tags, for bodies that may differ byte wise for the same content.
+	Weak bool
+}
+
+// ETag buffers GET and HEAD responses, tags 200s with a hash of the body (unless the handler set
+// an ETag itself) and turns them into a 304 or 412 when the conditional headers say so.
+// The handler still does all its work, only the bytes on the wire are saved; handlers that know
+// their validators up front should call response.CheckPreconditions instead.
+//
+// Unsafe methods (PUT, DELETE, ...) are left alone: by the time the body exists the change is done,
+// so If-Match has to be checked by the handler, with response.CheckPreconditions.
+func ETag(cfg ETagConfig) server.Middleware {
+	makeTag := response.StrongETag
+	if cfg.Weak {
+		makeTag = response.WeakETag
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if method != "GET" && method != "HEAD" {
+				next(w, req)
+				return
+			}
+
+			w.BufferBody()
+			w.OnCommit(func() {
+				if w.StatusCode() != response.StatusOK {
+					return
+				}
+
+				h := w.Header()
+				etag, _ := h.Get([]byte("ETag"))
+				// A HEAD handler usually writes no body, hashing that would be a lie.
+				if etag == "" && method == "GET" {
+					etag = makeTag(w.BufferedBody())
+					h.Set("ETag", etag)
+				}
+
+				lastModified, _ := h.Get([]byte("Last-Modified"))
+				modTime, _ := headers.ParseTime(lastModified)
+
+				switch req.EvaluatePreconditions(etag, modTime) {
+				case request.PreconditionNotModified:
+					response.NotModified(w)
+				case request.PreconditionFailed:
+					w.DiscardBody()
+					w.SetStatus(response.StatusPreconditionFailed)
+					h.Set("Content-Type", "text/plain")
+					w.WriteBody([]byte("precondition failed\n"))
+				}
+			})
+
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETag(t *testing.T) {
+	calls := 0
+	dashboard := func(w *response.Writer, req *request.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteBody([]byte(`{"cpu":42}`))
+	}
+	h := ETag(ETagConfig{})(dashboard)
+	etag := response.StrongETag([]byte(`{"cpu":42}`))
+
+	// Test: The body is tagged
+	out := run(t, h, "GET /stats HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "etag: "+etag+"\r\n")
+	assert.Contains(t, out, "content-length: 10\r\n")
+
+	// Test: Polling with the tag gets an empty 304
+	out = run(t, h, "GET /stats HTTP/1.1\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "etag: "+etag+"\r\n")
+	assert.NotContains(t, out, "content-type")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: A stale tag gets the full body, a failed If-Match a 412
+	out = run(t, h, "GET /stats HTTP/1.1\r\nIf-None-Match: \"old\"\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, `{"cpu":42}`))
+	out = run(t, h, "GET /stats HTTP/1.1\r\nIf-Match: \"old\"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+	assert.True(t, strings.HasSuffix(out, "precondition failed\n"))
+
+	// Test: Weak tags, and the handler's own tag wins
+	out = run(t, ETag(ETagConfig{Weak: true})(dashboard), "GET / HTTP/1.1\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n")) // If-None-Match compares weakly
+	assert.Contains(t, out, `etag: W/"`)
+
+	own := ETag(ETagConfig{})(func(w *response.Writer, req *request.Request) {
+		w.Header().Set("ETag", `"v7"`)
+		w.Header().Set("Last-Modified", "Wed, 01 May 2024 12:00:00 GMT")
+		w.WriteBody([]byte("data"))
+	})
+	out = run(t, own, "HEAD / HTTP/1.1\r\nIf-None-Match: \"v7\"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	out = run(t, own, "GET / HTTP/1.1\r\nIf-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Errors and unsafe methods are left alone
+	out = run(t, h, "POST /stats HTTP/1.1\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, out, "etag")
+	out = run(t, ETag(ETagConfig{})(okHandlerStatus(response.StatusBadRequest)), "GET / HTTP/1.1\r\nIf-None-Match: *\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// The handler ran every time, only the bytes on the wire were saved
+	assert.Equal(t, 6, calls)
+}
+
+func okHandlerStatus(code response.StatusCode) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		response.Error(w, code, response.StatusText(code))
+	}
+}
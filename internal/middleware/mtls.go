@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// IdentityRule protects every path under PathPrefix, the request goes through only when Allow says so.
+// The prefix matches whole segments: "/admin" covers "/admin" and "/admin/users", not "/administrator".
+type IdentityRule struct {
+	PathPrefix string
+	Allow      func(id *request.ClientIdentity) bool
+}
+
+// AllowSPIFFEIDs accepts clients carrying any of the given SPIFFE IDs.
+func AllowSPIFFEIDs(ids ...string) func(*request.ClientIdentity) bool {
+	return func(id *request.ClientIdentity) bool {
+		for _, want := range ids {
+			if id.HasSPIFFEID(want) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowDNSNames accepts clients carrying any of the given DNS SANs.
+func AllowDNSNames(names ...string) func(*request.ClientIdentity) bool {
+	return func(id *request.ClientIdentity) bool {
+		for _, want := range names {
+			if id.HasDNSName(want) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowAnyClient accepts any client with a verified certificate.
+func AllowAnyClient(id *request.ClientIdentity) bool {
+	return true
+}
+
+// RequireClientIdentity authorizes requests by their mTLS identity.
+// The longest matching PathPrefix decides, paths matching no rule pass untouched.
+// Paths are matched decoded and cleaned, the way the handlers resolve them, so "/x/../admin"
+// and "/%61dmin" are "/admin" here too. An undecodable path -> 400.
+// No verified certificate -> 401, certificate not allowed by the rule -> 403.
+func RequireClientIdentity(rules ...IdentityRule) server.Middleware {
+	rules = append([]IdentityRule(nil), rules...)
+	for i := range rules {
+		rules[i].PathPrefix = cleanPath(rules[i].PathPrefix)
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			p, ok := requestPath(req)
+			if !ok {
+				response.Error(w, response.StatusBadRequest, "bad request path\n")
+				return
+			}
+			rule := matchRule(rules, p)
+			if rule == nil {
+				next(w, req)
+				return
+			}
+
+			if req.ClientIdentity == nil {
+				response.Error(w, response.StatusUnauthorized, "client certificate required\n")
+				return
+			}
+
+			if !rule.Allow(req.ClientIdentity) {
+				response.Error(w, response.StatusForbidden, "client not allowed\n")
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+func matchRule(rules []IdentityRule, p string) *IdentityRule {
+	var best *IdentityRule
+	for i := range rules {
+		r := &rules[i]
+		if !underPrefix(p, r.PathPrefix) {
+			continue
+		}
+		if best == nil || len(r.PathPrefix) > len(best.PathPrefix) {
+			best = r
+		}
+	}
+	return best
+}
+
+// underPrefix matches whole segments, both paths being clean.
+func underPrefix(p, prefix string) bool {
+	return prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// requestPath is the decoded and cleaned path of the request target, without the query string.
+func requestPath(req *request.Request) (string, bool) {
+	rawPath, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	p, err := url.PathUnescape(rawPath)
+	if err != nil || strings.ContainsRune(p, 0) {
+		return "", false
+	}
+	return cleanPath(p), true
+}
+
+// cleanPath roots the path and drops the dot segments, duplicate and trailing slashes.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
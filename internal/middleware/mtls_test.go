@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// run sends a raw request through the handler and returns the raw response, finished like the server does.
+func run(t *testing.T, handler func(w *response.Writer, req *request.Request), raw string, setup ...func(*request.Request)) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	for _, fn := range setup {
+		fn(req)
+	}
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handler(w, req)
+	require.NoError(t, w.Close())
+	return out.String()
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	response.Error(w, response.StatusOK, "ok")
+}
+
+func TestRequireClientIdentity(t *testing.T) {
+	h := RequireClientIdentity(
+		IdentityRule{PathPrefix: "/admin", Allow: AllowSPIFFEIDs("spiffe://mesh/ns/ops/sa/admin")},
+		IdentityRule{PathPrefix: "/admin/health", Allow: AllowAnyClient},
+	)(okHandler)
+
+	withID := func(spiffe string) func(*request.Request) {
+		return func(r *request.Request) {
+			r.ClientIdentity = &request.ClientIdentity{SPIFFEIDs: []string{spiffe}}
+		}
+	}
+
+	// Test: Unprotected path passes without identity
+	out := run(t, h, "GET /public HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	// Test: Protected path without certificate
+	out = run(t, h, "GET /admin/users HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized"))
+
+	// Test: Wrong identity
+	out = run(t, h, "GET /admin/users HTTP/1.1\r\n\r\n", withID("spiffe://mesh/ns/web/sa/frontend"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden"))
+
+	// Test: Right identity
+	out = run(t, h, "GET /admin/users?page=2 HTTP/1.1\r\n\r\n", withID("spiffe://mesh/ns/ops/sa/admin"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	// Test: Longest prefix wins
+	out = run(t, h, "GET /admin/health HTTP/1.1\r\n\r\n", withID("spiffe://mesh/ns/web/sa/frontend"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	// Test: Dot segments, percent-encoding and extra slashes still reach the rule
+	for _, target := range []string{"/x/../admin/secret.txt", "/%61dmin/secret.txt", "//admin/secret.txt", "/admin/./secret.txt", "/%2fadmin/secret.txt", "/admin"} {
+		out = run(t, h, "GET "+target+" HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized"), target)
+	}
+	out = run(t, h, "GET /admin/health/../users HTTP/1.1\r\n\r\n", withID("spiffe://mesh/ns/web/sa/frontend"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden"))
+
+	// Test: Prefixes match whole segments
+	out = run(t, h, "GET /administrator HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	out = run(t, h, "GET /admin/healthz HTTP/1.1\r\n\r\n", withID("spiffe://mesh/ns/web/sa/frontend"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden"))
+
+	// Test: A path that doesn't decode is refused
+	out = run(t, h, "GET /%zzadmin HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request"))
+
+	// Test: A rule written with a trailing slash covers the directory itself
+	h = RequireClientIdentity(IdentityRule{PathPrefix: "/ops/", Allow: AllowAnyClient})(okHandler)
+	out = run(t, h, "GET /ops HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized"))
+}
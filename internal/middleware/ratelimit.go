@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// KeyFunc picks who a request is counted against, an empty key falls back to the remote IP.
+type KeyFunc func(req *request.Request) string
+
+// KeyByRemoteIP counts requests per client IP.
+// A unix socket has no IP: RemoteAddr is the client's socket name, almost always "@" or empty,
+// so every local client shares one bucket. Behind such a listener, use a KeyFunc that tells
+// the clients apart (KeyByHeader, an authenticated user, ...).
+func KeyByRemoteIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader counts requests per value of a header, e.g. an API key.
+func KeyByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		value, err := req.Headers.Get([]byte(name))
+		if err != nil {
+			return ""
+		}
+		return name + ":" + value
+	}
+}
+
+// RateLimitConfig is a token bucket per key: Burst requests at once, refilled at Rate per second.
+type RateLimitConfig struct {
+	Rate  float64
+	Burst int
+	Key   KeyFunc // KeyByRemoteIP when nil
+	// IdleTTL evicts the buckets of keys not seen for that long, 10 minutes when zero.
+	IdleTTL time.Duration
+	// MaxKeys caps the buckets kept, 100000 when zero. Over it the least recently seen key is
+	// forgotten (and starts over with a full bucket), so a client making up keys can't grow the map.
+	MaxKeys int
+}
+
+// RateLimiter keeps the buckets in memory.
+type RateLimiter struct {
+	cfg       RateLimitConfig
+	mu        sync.Mutex
+	buckets   map[string]*list.Element // of *bucket
+	recent    *list.List               // most recently seen first
+	lastSweep time.Time
+	now       func() time.Time // replaced in tests
+}
+
+type bucket struct {
+	key      string
+	tokens   float64
+	lastSeen time.Time
+}
+
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	if cfg.Key == nil {
+		cfg.Key = KeyByRemoteIP
+	}
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = 10 * time.Minute
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 100000
+	}
+
+	return &RateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*list.Element),
+		recent:  list.New(),
+		now:     time.Now,
+	}
+}
+
+// RateLimit is NewRateLimiter(cfg).Middleware().
+func RateLimit(cfg RateLimitConfig) server.Middleware {
+	return NewRateLimiter(cfg).Middleware()
+}
+
+// RateLimitResult is the outcome of Allow.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // tokens left
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, zero when allowed
+}
+
+// Allow takes a token from the key's bucket.
+func (rl *RateLimiter) Allow(key string) RateLimitResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	var b *bucket
+	if e, ok := rl.buckets[key]; ok {
+		b = e.Value.(*bucket)
+		rl.recent.MoveToFront(e)
+		// refill for the time elapsed since the last request
+		b.tokens = math.Min(float64(rl.cfg.Burst), b.tokens+now.Sub(b.lastSeen).Seconds()*rl.cfg.Rate)
+	} else {
+		if len(rl.buckets) >= rl.cfg.MaxKeys {
+			rl.remove(rl.recent.Back())
+		}
+		b = &bucket{key: key, tokens: float64(rl.cfg.Burst)}
+		rl.buckets[key] = rl.recent.PushFront(b)
+	}
+	b.lastSeen = now
+
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = rl.timeFor(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = rl.timeFor(float64(rl.cfg.Burst) - b.tokens)
+
+	return res
+}
+
+// Middleware answers 429 with Retry-After once a key runs out of tokens,
+// every response carries the RateLimit-Limit/Remaining/Reset headers.
+func (rl *RateLimiter) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			key := rl.cfg.Key(req)
+			if key == "" {
+				key = KeyByRemoteIP(req)
+			}
+
+			res := rl.Allow(key)
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(rl.cfg.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				response.Error(w, response.StatusTooManyRequests, "rate limit exceeded\n")
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+// timeFor is how long the refill takes to produce that many tokens.
+func (rl *RateLimiter) timeFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if rl.cfg.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / rl.cfg.Rate * float64(time.Second))
+}
+
+// sweep drops the idle buckets, at most every IdleTTL/2. They are all at the back of the list,
+// so it stops at the first one still in use. Caller holds the lock.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.cfg.IdleTTL/2 {
+		return
+	}
+	rl.lastSweep = now
+
+	for e := rl.recent.Back(); e != nil && now.Sub(e.Value.(*bucket).lastSeen) > rl.cfg.IdleTTL; e = rl.recent.Back() {
+		rl.remove(e)
+	}
+}
+
+// remove forgets a bucket. Caller holds the lock.
+func (rl *RateLimiter) remove(e *list.Element) {
+	delete(rl.buckets, e.Value.(*bucket).key)
+	rl.recent.Remove(e)
+}
+
+// Len is the number of keys currently tracked.
+func (rl *RateLimiter) Len() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 2})
+	now := time.Unix(1000, 0)
+	rl.now = func() time.Time { return now }
+	h := rl.Middleware()(okHandler)
+
+	from := func(addr string) func(*request.Request) {
+		return func(r *request.Request) { r.RemoteAddr = addr }
+	}
+	get := "GET / HTTP/1.1\r\n\r\n"
+
+	// Test: The burst goes through
+	out := run(t, h, get, from("10.0.0.1:5000"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	assert.Contains(t, out, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, out, "ratelimit-remaining: 1\r\n")
+	out = run(t, h, get, from("10.0.0.1:5001"))
+	assert.Contains(t, out, "ratelimit-remaining: 0\r\n")
+	assert.Contains(t, out, "ratelimit-reset: 2\r\n")
+
+	// Test: Out of tokens, same IP from another port
+	out = run(t, h, get, from("10.0.0.1:5002"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests"))
+	assert.Contains(t, out, "retry-after: 1\r\n")
+
+	// Test: Another client has its own bucket
+	out = run(t, h, get, from("10.0.0.2:5000"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	// Test: Refilled after a second
+	now = now.Add(time.Second)
+	out = run(t, h, get, from("10.0.0.1:5003"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	// Test: Idle keys are evicted
+	assert.Equal(t, 2, rl.Len())
+	now = now.Add(time.Hour)
+	rl.Allow("10.0.0.3")
+	assert.Equal(t, 1, rl.Len())
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	h := RateLimit(RateLimitConfig{Rate: 0.1, Burst: 1, Key: KeyByHeader("X-API-Key")})
+	handler := h(okHandler)
+	local := func(r *request.Request) { r.RemoteAddr = "127.0.0.1:1" }
+
+	// Test: Each API key is counted on its own, from the same IP
+	out := run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: alpha\r\n\r\n", local)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: beta\r\n\r\n", local)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: alpha\r\n\r\n", local)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests"))
+	assert.Contains(t, out, "retry-after: 10\r\n")
+
+	// Test: No key, counted by IP
+	out = run(t, handler, "GET / HTTP/1.1\r\n\r\n", local)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+}
+
+func TestRateLimitUnixSocket(t *testing.T) {
+	unix := func(r *request.Request) { r.RemoteAddr = "@" }
+
+	// Test: No IP to tell local clients apart, they all count against the socket name
+	req := &request.Request{RemoteAddr: "@"}
+	assert.Equal(t, "@", KeyByRemoteIP(req))
+	handler := RateLimit(RateLimitConfig{Rate: 0.1, Burst: 1})(okHandler)
+	out := run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: alpha\r\n\r\n", unix)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: beta\r\n\r\n", unix)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests"))
+
+	// Test: A key of their own keeps them apart
+	handler = RateLimit(RateLimitConfig{Rate: 0.1, Burst: 1, Key: KeyByHeader("X-API-Key")})(okHandler)
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: alpha\r\n\r\n", unix)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: beta\r\n\r\n", unix)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+}
+
+func TestRateLimitMaxKeys(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{Rate: 0.1, Burst: 1, MaxKeys: 2})
+
+	// Test: Made up keys don't grow the map, the least recently seen one goes
+	assert.True(t, rl.Allow("a").Allowed)
+	assert.True(t, rl.Allow("b").Allowed)
+	assert.False(t, rl.Allow("a").Allowed) // "a" is now the most recent
+	for i := 0; i < 100; i++ {
+		rl.Allow(strings.Repeat("x", i+1))
+		rl.Allow("a")
+	}
+	assert.Equal(t, 2, rl.Len())
+
+	// Test: A key in use stays tracked (and limited)
+	assert.False(t, rl.Allow("a").Allowed)
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+const maxRequestIDLength = 128
+
+// RequestIDConfig tunes the RequestID middleware.
+type RequestIDConfig struct {
+	// Generate makes a new id, 16 random bytes in hex when nil.
+	Generate func() string
+	// TrustIncoming keeps a valid X-Request-ID sent by the client (or the proxy in front of us).
+	TrustIncoming bool
+}
+
+// RequestID gives every request an id: the incoming X-Request-ID when trusted and valid,
+// a new one otherwise. It is stored in the request context and sent back on the response.
+func RequestID(cfg RequestIDConfig) server.Middleware {
+	if cfg.Generate == nil {
+		cfg.Generate = NewRequestID
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id := ""
+			if cfg.TrustIncoming {
+				if incoming, err := req.Headers.Get([]byte(request.RequestIDHeader)); err == nil && ValidRequestID(incoming) {
+					id = incoming
+				}
+			}
+			if id == "" {
+				id = cfg.Generate()
+			}
+
+			w.Header().Set(request.RequestIDHeader, id)
+			next(w, req.WithContext(request.ContextWithRequestID(req.Context(), id)))
+		}
+	}
+}
+
+// NewRequestID is 16 random bytes in hex.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ValidRequestID accepts 1 to 128 characters of [A-Za-z0-9._:+/=-], enough for uuids, hex and base64
+// ids, and nothing that could break a header or a log line.
+func ValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	echoID := func(w *response.Writer, req *request.Request) {
+		seen = req.ID()
+		response.Error(w, response.StatusOK, "ok")
+	}
+
+	trusting := RequestID(RequestIDConfig{TrustIncoming: true, Generate: func() string { return "generated" }})(echoID)
+
+	// Test: A valid incoming id is kept
+	out := run(t, trusting, "GET / HTTP/1.1\r\nX-Request-ID: 3f1c-abc_DEF.1\r\n\r\n")
+	assert.Equal(t, "3f1c-abc_DEF.1", seen)
+	assert.Contains(t, out, "x-request-id: 3f1c-abc_DEF.1\r\n")
+
+	// Test: Missing, too long or weird ids are replaced
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nX-Request-ID: " + strings.Repeat("a", 129) + "\r\n\r\n",
+		"GET / HTTP/1.1\r\nX-Request-ID: hello world\r\n\r\n",
+		"GET / HTTP/1.1\r\nX-Request-ID: <script>\r\n\r\n",
+	} {
+		out = run(t, trusting, raw)
+		assert.Equal(t, "generated", seen)
+		assert.Contains(t, out, "x-request-id: generated\r\n")
+	}
+
+	// Test: Incoming ids are ignored unless trusted, the default generator is 32 hex chars
+	out = run(t, RequestID(RequestIDConfig{})(echoID), "GET / HTTP/1.1\r\nX-Request-ID: abc\r\n\r\n")
+	assert.Regexp(t, `^[0-9a-f]{32}$`, seen)
+	assert.Contains(t, out, "x-request-id: "+seen+"\r\n")
+}
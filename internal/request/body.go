@@ -0,0 +1,238 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// A chunk size line (with its extensions) or a trailer line longer than this is refused.
+const maxChunkLineBytes = 4096
+
+// chunked reports whether the body uses the chunked framing. It must be the last coding
+// (RFC 9112 section 6.3), and it wins over a Content-Length.
+func (r *Request) chunked() bool {
+	te, err := r.Headers.Get([]byte("Transfer-Encoding"))
+	if err != nil {
+		return false
+	}
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// streamed reports whether the body stays on the stream for the handler, see Limits.StreamMultipart.
+func (r *Request) streamed() bool {
+	if !r.limits.StreamMultipart {
+		return false
+	}
+	contentType, err := r.Headers.Get([]byte("Content-Type"))
+	if err != nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// bodyFromStream sets up a body the parser left on the stream: a chunked one, or a streamed one.
+// A streamed body becomes BodyStream, the others are read into Body right away.
+func (r *Request) bodyFromStream() error {
+	var src bodySource
+	if r.chunked() {
+		br := bufio.NewReaderSize(r.rest, maxChunkLineBytes)
+		// The chunks are read through br, what comes after the body may be buffered there.
+		r.rest = br
+		src = &chunkedReader{br: br}
+	} else {
+		cl, _ := r.Headers.Get([]byte("Content-Length"))
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return ERROR_PARSING_BODY_INVALID_CONTENT_LENGTH
+		}
+		src = &lengthReader{r: r.rest, left: n}
+	}
+
+	body := &bodyReader{r: src, max: int64(r.limits.MaxBodyBytes)}
+	if r.streamed() {
+		r.stream = body
+		r.BodyStream = body
+		return nil
+	}
+
+	var err error
+	r.Body, err = io.ReadAll(body)
+	return err
+}
+
+// BodyBytes is the size of the body received so far: len(Body), or what was read from BodyStream.
+func (r *Request) BodyBytes() int64 {
+	if r.stream != nil {
+		return r.stream.n
+	}
+	return int64(len(r.Body))
+}
+
+// BodyUnread reports whether the handler left part of BodyStream on the connection.
+// The server then reads it out before closing, so the client isn't reset before it gets the response.
+func (r *Request) BodyUnread() bool {
+	return r.stream != nil && !r.stream.finished()
+}
+
+// bodySource reads the body off the stream, knowing when it is all read.
+type bodySource interface {
+	io.Reader
+	finished() bool
+}
+
+// bodyReader counts the body bytes and stops at MaxBodyBytes, which a chunked body can only be
+// held to as it goes.
+type bodyReader struct {
+	r   bodySource
+	n   int64
+	max int64 // no limit when zero
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.max > 0 && b.n > b.max {
+		return 0, ERROR_BODY_TOO_LARGE
+	}
+	// One byte past the limit tells "exactly max" from "more".
+	if b.max > 0 && int64(len(p)) > b.max-b.n+1 {
+		p = p[:b.max-b.n+1]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.max > 0 && b.n > b.max {
+		return n - int(b.n-b.max), ERROR_BODY_TOO_LARGE
+	}
+	return n, err
+}
+
+func (b *bodyReader) finished() bool {
+	return b.r.finished()
+}
+
+// lengthReader reads a body of Content-Length bytes, a stream ending before is an error.
+type lengthReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if err == io.EOF && l.left > 0 {
+		err = ERROR_UNEXPECTED_EOF
+	}
+	return n, err
+}
+
+func (l *lengthReader) finished() bool {
+	return l.left <= 0
+}
+
+// chunkedReader decodes a chunked body (RFC 9112 section 7.1), the extensions and trailers are skipped.
+type chunkedReader struct {
+	br   *bufio.Reader
+	left int64 // bytes left in the current chunk
+	err  error // sticky, io.EOF after the last chunk
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.br.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = ERROR_UNEXPECTED_EOF
+	}
+	if err == nil && c.left == 0 {
+		// the data of a chunk ends with a CRLF
+		err = c.crlf()
+	}
+	c.err = err
+	return n, err
+}
+
+func (c *chunkedReader) finished() bool {
+	return c.err == io.EOF
+}
+
+// nextChunk reads a chunk size line, or the last chunk and the trailers after it.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	sizeHex, _, _ := strings.Cut(string(line), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: bad chunk size %q", ERROR_MALFORMED_CHUNKED, sizeHex)
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+
+	// Last chunk, skip the trailers up to the empty line.
+	for {
+		line, err := c.line()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return io.EOF
+		}
+	}
+}
+
+// line reads a line ending in CRLF, returned without it.
+func (c *chunkedReader) line() ([]byte, error) {
+	line, err := c.br.ReadSlice('\n')
+	switch {
+	case err == io.EOF:
+		return nil, ERROR_UNEXPECTED_EOF
+	case err == bufio.ErrBufferFull:
+		return nil, fmt.Errorf("%w: line too long", ERROR_MALFORMED_CHUNKED)
+	case err != nil:
+		return nil, err
+	}
+	line, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		return nil, fmt.Errorf("%w: line not ended by CRLF", ERROR_MALFORMED_CHUNKED)
+	}
+	return line, nil
+}
+
+func (c *chunkedReader) crlf() error {
+	var end [2]byte
+	if _, err := io.ReadFull(c.br, end[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ERROR_UNEXPECTED_EOF
+		}
+		return err
+	}
+	if end != [2]byte{'\r', '\n'} {
+		return fmt.Errorf("%w: chunk data not ended by CRLF", ERROR_MALFORMED_CHUNKED)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package request
+
+import (
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+)
+
+// Precondition is the outcome of the conditional headers of a request.
+type Precondition int
+
+const (
+	PreconditionPassed      Precondition = iota // go on and answer normally
+	PreconditionNotModified                     // the client's copy is fresh, answer 304
+	PreconditionFailed                          // answer 412
+)
+
+// EvaluatePreconditions checks If-Match, If-Unmodified-Since, If-None-Match and If-Modified-Since
+// against the current validators of the resource, in the order of RFC 9110 section 13.2.2.
+// etag is the full entity tag ("abc" with its quotes, or W/"abc"), empty when there is none,
+// and a zero lastModified means unknown.
+func (r *Request) EvaluatePreconditions(etag string, lastModified time.Time) Precondition {
+	current, hasETag := headers.ParseETag(etag)
+	lastModified = lastModified.UTC().Truncate(time.Second) // HTTP dates have no sub-second part
+
+	// 1. If-Match, otherwise 2. If-Unmodified-Since
+	if ifMatch, err := r.Headers.Get([]byte("If-Match")); err == nil {
+		if !matchesAny(ifMatch, current, hasETag, headers.ETag.StrongMatch) {
+			return PreconditionFailed
+		}
+	} else if since, ok := r.headerTime("If-Unmodified-Since"); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return PreconditionFailed
+		}
+	}
+
+	safe := r.RequestLine.Method == "GET" || r.RequestLine.Method == "HEAD"
+
+	// 3. If-None-Match, otherwise 4. If-Modified-Since (GET and HEAD only)
+	if ifNoneMatch, err := r.Headers.Get([]byte("If-None-Match")); err == nil {
+		if matchesAny(ifNoneMatch, current, hasETag, headers.ETag.WeakMatch) {
+			if safe {
+				return PreconditionNotModified
+			}
+			return PreconditionFailed
+		}
+	} else if since, ok := r.headerTime("If-Modified-Since"); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return PreconditionNotModified
+		}
+	}
+
+	return PreconditionPassed
+}
+
+// matchesAny is true for "*" or when one of the listed tags matches the current one.
+// The handler calling this has a current representation, so "*" always matches.
+func matchesAny(list string, current headers.ETag, hasETag bool, match func(a, b headers.ETag) bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if !hasETag {
+		return false
+	}
+	tags, ok := headers.ParseETagList(list)
+	if !ok {
+		return false
+	}
+	for _, tag := range tags {
+		if match(tag, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTime reads a date header, an invalid date is ignored like the RFC says.
+func (r *Request) headerTime(name string) (time.Time, bool) {
+	value, err := r.Headers.Get([]byte(name))
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := headers.ParseTime(value)
+	return t, err == nil
+}
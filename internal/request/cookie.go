@@ -0,0 +1,29 @@
+package request
+
+import (
+	"fmt"
+
+	"boot.mossad.http/internal/cookie"
+)
+
+var ERROR_NO_COOKIE = fmt.Errorf("named cookie not present")
+
+// Cookies parses the Cookie header, invalid pairs are left out.
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, err := r.Headers.Get([]byte("Cookie"))
+	if err != nil {
+		return nil
+	}
+	return cookie.Parse(header)
+}
+
+// Cookie is the first cookie with that name, ERROR_NO_COOKIE when there is none.
+// Browsers send the most specific path first, so first is the one to use.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ERROR_NO_COOKIE
+}
@@ -0,0 +1,225 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Defaults for the form limits of Limits, a form is small unless said otherwise.
+const (
+	DefaultMaxFormKeys  = 1000
+	DefaultMaxFormBytes = 10 << 20
+)
+
+// Every form error wraps ERROR_INVALID_FORM, so a handler only has to check for that one
+// and answer 400.
+var ERROR_INVALID_FORM = fmt.Errorf("invalid form")
+var ERROR_FORM_TOO_MANY_KEYS = fmt.Errorf("%w: too many keys", ERROR_INVALID_FORM)
+var ERROR_FORM_TOO_LARGE = fmt.Errorf("%w: too large", ERROR_INVALID_FORM)
+
+// Values maps a key to all its values, in the order they came: a=1&a=2 is {"a": ["1", "2"]}.
+type Values map[string][]string
+
+// Get is the first value of key, empty when there is none.
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Has reports whether key was sent at all, even with an empty value.
+func (v Values) Has(key string) bool {
+	_, ok := v[key]
+	return ok
+}
+
+// Add appends a value to key.
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Set replaces the values of key.
+func (v Values) Set(key, value string) {
+	v[key] = []string{value}
+}
+
+// ParseQuery decodes a query string or an application/x-www-form-urlencoded body.
+// "+" is a space, %XX a byte, a pair without "=" is a key with an empty value.
+// maxKeys and maxBytes are not checked when zero.
+func ParseQuery(query string, maxKeys, maxBytes int) (Values, error) {
+	if maxKeys <= 0 {
+		maxKeys = -1
+	}
+	if maxBytes <= 0 {
+		maxBytes = -1
+	}
+	values := Values{}
+	if err := parseQueryInto(values, query, maxKeys, maxBytes); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseQueryInto is ParseQuery adding to values, the limits are what is left of a budget:
+// zero allows nothing, negative is not checked.
+func parseQueryInto(values Values, query string, maxKeys, maxBytes int) error {
+	if maxBytes >= 0 && len(query) > maxBytes {
+		return ERROR_FORM_TOO_LARGE
+	}
+
+	pairs := 0
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		if pair == "" {
+			continue // a&&b, or a trailing &
+		}
+		pairs++
+		if maxKeys >= 0 && pairs > maxKeys {
+			return ERROR_FORM_TOO_MANY_KEYS
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := unescape(key)
+		if err != nil {
+			return err
+		}
+		value, err = unescape(value)
+		if err != nil {
+			return err
+		}
+		values.Add(key, value)
+	}
+	return nil
+}
+
+// unescape undoes the form encoding, same rules in the query and in the body.
+func unescape(s string) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '+':
+			b.WriteByte(' ')
+		case '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				end := min(i+3, len(s))
+				return "", fmt.Errorf("%w: bad escape %q", ERROR_INVALID_FORM, s[i:end])
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// RawQuery is the part of the target after "?", without the "#fragment" a broken client may send.
+func (r *Request) RawQuery() string {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	query, _, _ = strings.Cut(query, "#")
+	return query
+}
+
+// Query decodes the query string of the target, parsed once and cached.
+// Don't modify the returned map, use a copy for that.
+func (r *Request) Query() (Values, error) {
+	if r.query == nil && r.queryErr == nil {
+		r.query, r.queryErr = ParseQuery(r.RawQuery(), r.formKeys(), r.formBytes())
+	}
+	return r.query, r.queryErr
+}
+
+// ParseForm fills Form with the query string and, for POST, PUT and PATCH with an
+// application/x-www-form-urlencoded body, PostForm with the body. Body values come first in
+// Form, like net/http does. Calling it again is a no-op. Errors wrap ERROR_INVALID_FORM.
+//
+// MaxFormKeys and MaxFormBytes are one budget for the whole Form: the body gets what the query
+// string left of it.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	query, err := r.Query()
+	if err != nil {
+		return err
+	}
+
+	r.PostForm = Values{}
+	method := r.RequestLine.Method
+	if method == "POST" || method == "PUT" || method == "PATCH" {
+		contentType, _ := r.Headers.Get([]byte("Content-Type"))
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/x-www-form-urlencoded") {
+			keys, size := r.formBudget()
+			if err := parseQueryInto(r.PostForm, string(r.Body), keys, size); err != nil {
+				r.PostForm = nil
+				return err
+			}
+		}
+	}
+
+	form := Values{}
+	for key, vs := range r.PostForm {
+		form[key] = append(form[key], vs...)
+	}
+	for key, vs := range query {
+		form[key] = append(form[key], vs...)
+	}
+	r.Form = form
+	return nil
+}
+
+// FormValue is the first value of key in Form, parsing it when needed. Errors are ignored,
+// call ParseForm first to see them.
+func (r *Request) FormValue(key string) string {
+	r.ParseForm()
+	return r.Form.Get(key)
+}
+
+func (r *Request) formKeys() int {
+	if r.limits.MaxFormKeys > 0 {
+		return r.limits.MaxFormKeys
+	}
+	return DefaultMaxFormKeys
+}
+
+func (r *Request) formBytes() int {
+	if r.limits.MaxFormBytes > 0 {
+		return r.limits.MaxFormBytes
+	}
+	return DefaultMaxFormBytes
+}
+
+// formBudget is what the body may still add to Form once the query string took its share
+// of MaxFormKeys and MaxFormBytes. Only called once Query succeeded.
+func (r *Request) formBudget() (keys, size int) {
+	query, _ := r.Query()
+	pairs := 0
+	for _, vs := range query {
+		pairs += len(vs)
+	}
+	return max(r.formKeys()-pairs, 0), max(r.formBytes()-len(r.RawQuery()), 0)
+}
@@ -0,0 +1,58 @@
+package request
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+)
+
+// ClientIdentity is who the peer is, taken from a client certificate that passed verification (mTLS).
+type ClientIdentity struct {
+	Subject        string // full subject DN, e.g. "CN=billing,O=Acme"
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string // every URI SAN
+	SPIFFEIDs      []string // the URI SANs with the spiffe:// scheme
+}
+
+// IdentityFromCertificate extracts the identity fields of a verified peer certificate.
+func IdentityFromCertificate(cert *x509.Certificate) *ClientIdentity {
+	id := &ClientIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+	}
+
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+		if strings.EqualFold(u.Scheme, "spiffe") {
+			id.SPIFFEIDs = append(id.SPIFFEIDs, u.String())
+		}
+	}
+
+	return id
+}
+
+// HasSPIFFEID reports whether the identity carries the exact SPIFFE ID.
+func (id *ClientIdentity) HasSPIFFEID(spiffeID string) bool {
+	for _, s := range id.SPIFFEIDs {
+		if s == spiffeID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDNSName reports whether the identity carries the DNS SAN (case insensitive).
+func (id *ClientIdentity) HasDNSName(name string) bool {
+	for _, n := range id.DNSNames {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
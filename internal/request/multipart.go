@@ -0,0 +1,531 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+
+	"boot.mossad.http/internal/headers"
+)
+
+// DefaultMaxMemory is how much of the uploaded files ParseMultipartForm keeps in memory
+// when given zero, the rest goes to temp files.
+const DefaultMaxMemory = 32 << 20
+
+// The multipart errors wrap ERROR_INVALID_FORM too, a broken upload is a 400 like a broken form.
+var ERROR_NOT_MULTIPART = fmt.Errorf("%w: not multipart/form-data", ERROR_INVALID_FORM)
+var ERROR_MALFORMED_MULTIPART = fmt.Errorf("%w: malformed multipart body", ERROR_INVALID_FORM)
+
+// A boundary is at most 70 characters (RFC 2046 section 5.1.1), the part headers get 8 KB.
+const (
+	maxBoundaryLen     = 70
+	maxPartHeaderBytes = 8 << 10
+)
+
+// MultipartReader walks the parts of a multipart body one at a time, nothing is buffered
+// besides the part being read.
+type MultipartReader struct {
+	br             *bufio.Reader
+	dashBoundary   []byte // "--boundary"
+	nlDashBoundary []byte // "\r\n--boundary", what ends a part
+	current        *Part
+	started        bool
+	done           bool
+}
+
+// Part is one part of the body. Read it like any io.Reader, until io.EOF.
+type Part struct {
+	Header headers.Headers
+
+	mr          *MultipartReader
+	eof         bool
+	disposition string
+	params      map[string]string
+}
+
+// NewMultipartReader reads the parts of body, boundary being the boundary parameter of the Content-Type.
+func NewMultipartReader(body io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		br:             bufio.NewReaderSize(body, 4096),
+		dashBoundary:   []byte("--" + boundary),
+		nlDashBoundary: []byte("\r\n--" + boundary),
+	}
+}
+
+// MultipartReader checks this is a multipart/form-data request and returns a reader over its body.
+// Use it to go through the parts yourself, or ParseMultipartForm to have them collected.
+// The parts come from BodyStream when the server left the body on the connection, from Body otherwise.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	contentType, err := r.Headers.Get([]byte("Content-Type"))
+	if err != nil {
+		return nil, ERROR_NOT_MULTIPART
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ERROR_NOT_MULTIPART
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > maxBoundaryLen {
+		return nil, fmt.Errorf("%w: bad boundary", ERROR_MALFORMED_MULTIPART)
+	}
+	if r.BodyStream != nil {
+		return NewMultipartReader(r.BodyStream, boundary), nil
+	}
+	return NewMultipartReader(bytes.NewReader(r.Body), boundary), nil
+}
+
+// NextPart skips what is left of the current part and returns the next one, io.EOF after the last.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		// The part stopped right before "\r\n--boundary", what follows says if there is more.
+		mr.br.Discard(len(mr.nlDashBoundary))
+		last, err := mr.delimiterEnd()
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return mr.finish()
+		}
+	} else if !mr.started {
+		mr.started = true
+		last, err := mr.skipPreamble()
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return mr.finish()
+		}
+	}
+
+	part := &Part{Header: headers.NewHeaders(), mr: mr}
+	if err := mr.readPartHeaders(part.Header); err != nil {
+		return nil, err
+	}
+	if cd, err := part.Header.Get([]byte("Content-Disposition")); err == nil {
+		part.disposition, part.params, _ = mime.ParseMediaType(cd)
+	}
+	mr.current = part
+	return part, nil
+}
+
+func (mr *MultipartReader) finish() (*Part, error) {
+	mr.done = true
+	mr.current = nil
+	return nil, io.EOF
+}
+
+// skipPreamble reads up to the first delimiter line, anything before it is ignored.
+func (mr *MultipartReader) skipPreamble() (last bool, err error) {
+	for {
+		line, err := mr.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue // a long preamble line, can't be the delimiter anyway
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: no boundary found", ERROR_MALFORMED_MULTIPART)
+		}
+		rest, ok := bytes.CutPrefix(line, mr.dashBoundary)
+		if !ok {
+			continue
+		}
+		rest = bytes.TrimRight(rest, " \t\r\n")
+		switch string(rest) {
+		case "":
+			return false, nil
+		case "--":
+			return true, nil
+		}
+		// "--boundaryX" is just a line that looks like one
+	}
+}
+
+// delimiterEnd reads the rest of a delimiter line: "--" after the last part, only
+// whitespace otherwise.
+func (mr *MultipartReader) delimiterEnd() (last bool, err error) {
+	line, err := mr.br.ReadSlice('\n')
+	if err == io.EOF && bytes.HasPrefix(line, []byte("--")) {
+		return true, nil // the final "--" without its CRLF, let it pass
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: unterminated delimiter", ERROR_MALFORMED_MULTIPART)
+	}
+	rest := bytes.TrimRight(line, " \t\r\n")
+	switch string(rest) {
+	case "":
+		return false, nil
+	case "--":
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: bad delimiter", ERROR_MALFORMED_MULTIPART)
+}
+
+// readPartHeaders feeds the header lines to the same parser as the request headers.
+func (mr *MultipartReader) readPartHeaders(h headers.Headers) error {
+	read := 0
+	for {
+		line, err := mr.br.ReadSlice('\n')
+		read += len(line)
+		if read > maxPartHeaderBytes || err == bufio.ErrBufferFull {
+			return fmt.Errorf("%w: part headers too large", ERROR_MALFORMED_MULTIPART)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: unexpected end in part headers", ERROR_MALFORMED_MULTIPART)
+		}
+		n, done, err := h.Parse(line)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ERROR_MALFORMED_MULTIPART, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: part header without CRLF", ERROR_MALFORMED_MULTIPART)
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Read gives the part's bytes, stopping before the next delimiter.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	mr := p.mr
+
+	// A full buffer (or the end of the body) is enough to see a whole delimiter.
+	peek, err := mr.br.Peek(mr.br.Size())
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	if idx := mr.delimiterIndex(peek, err == io.EOF); idx >= 0 {
+		if idx == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		n := copy(b, peek[:idx])
+		mr.br.Discard(n)
+		return n, nil
+	}
+
+	if err == io.EOF {
+		// The body ended inside the part: hand out what is left, then complain.
+		if len(peek) == 0 {
+			return 0, fmt.Errorf("%w: unexpected end in part", ERROR_MALFORMED_MULTIPART)
+		}
+		n := copy(b, peek)
+		mr.br.Discard(n)
+		return n, nil
+	}
+
+	// The tail may be the start of a delimiter, keep it for the next call.
+	n := copy(b, peek[:len(peek)-len(mr.nlDashBoundary)+1])
+	mr.br.Discard(n)
+	return n, nil
+}
+
+// delimiterIndex finds the first real delimiter in buf, -1 when there is none. "\r\n--boundary" is
+// only one when followed by "--" or by whitespace and CRLF, "--boundaryX" is content.
+// A candidate too close to the end to tell counts as one, the next Read sees it in full.
+func (mr *MultipartReader) delimiterIndex(buf []byte, eof bool) int {
+	offset := 0
+	for {
+		i := bytes.Index(buf[offset:], mr.nlDashBoundary)
+		if i < 0 {
+			return -1
+		}
+		idx := offset + i
+		after := buf[idx+len(mr.nlDashBoundary):]
+		if bytes.HasPrefix(after, []byte("--")) {
+			return idx
+		}
+		rest := bytes.TrimLeft(after, " \t")
+		if bytes.HasPrefix(rest, []byte("\r\n")) {
+			return idx
+		}
+		if len(rest) == 0 || (!eof && (bytes.Equal(rest, []byte("\r")) || bytes.Equal(after, []byte("-")))) {
+			if idx > 0 || eof {
+				return idx
+			}
+		}
+		offset = idx + 1
+	}
+}
+
+// FormName is the name parameter of the form-data Content-Disposition, empty otherwise.
+func (p *Part) FormName() string {
+	if p.disposition != "form-data" {
+		return ""
+	}
+	return p.params["name"]
+}
+
+// FileName is the filename parameter of the Content-Disposition, without any directory
+// (some browsers send the full client path), empty for plain fields.
+func (p *Part) FileName() string {
+	name := p.params["filename"]
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file, Open gives its content.
+type FileHeader struct {
+	Filename string
+	Header   headers.Headers
+	Size     int64
+
+	content []byte // when it fit in memory
+	tmpfile string // otherwise
+}
+
+// File is the content of an upload, from memory or from its temp file.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+// Open opens the uploaded content, close it when done.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return memFile{bytes.NewReader(fh.content)}, nil
+}
+
+// RemoveAll deletes the temp files of the form. The server does it once the handler
+// returned, call it yourself only to free the disk earlier.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm reads the whole multipart/form-data body into MultipartForm. The fields also
+// go to Form and PostForm. Files are kept in memory up to maxMemory bytes in total (DefaultMaxMemory
+// when zero), the ones after that are written to temp files, removed when the handler returns.
+//
+// The limits of the request apply: MaxFormKeys parts and MaxFormBytes of plain fields (what the query
+// string left of them, as in ParseForm), MaxPartBytes per part and MaxUploadBytes for all the parts
+// together. Errors wrap ERROR_INVALID_FORM.
+//
+// The server leaves multipart bodies on the connection (BodyStream), the parts are read as they come:
+// at most maxMemory of files and MaxFormBytes of fields are held in memory, whatever the upload size.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	if maxMemory <= 0 {
+		maxMemory = DefaultMaxMemory
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	form := &MultipartForm{Value: Values{}, File: map[string][]*FileHeader{}}
+	// Registered right away, so a failure half way still cleans up what was written.
+	r.onCleanup(form.RemoveAll)
+
+	if err := r.readMultipart(mr, form, maxMemory); err != nil {
+		form.RemoveAll()
+		return err
+	}
+
+	for key, vs := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], vs...)
+		r.Form[key] = append(r.Form[key], vs...)
+	}
+	r.MultipartForm = form
+	return nil
+}
+
+func (r *Request) readMultipart(mr *MultipartReader, form *MultipartForm, maxMemory int64) error {
+	partLimit := int64(r.limits.MaxPartBytes)
+	totalLimit := int64(r.limits.MaxUploadBytes)
+	maxParts, fieldBytes := r.formBudget()
+	valueBytes := int64(fieldBytes)
+
+	parts := 0
+	var total int64
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		parts++
+		if parts > maxParts {
+			return ERROR_FORM_TOO_MANY_KEYS
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue // not a form field, skipped by NextPart
+		}
+
+		// How much this part may still read, -1 for no limit.
+		limit := int64(-1)
+		if partLimit > 0 {
+			limit = partLimit
+		}
+		if totalLimit > 0 && (limit < 0 || totalLimit-total < limit) {
+			limit = totalLimit - total
+		}
+
+		filename := part.FileName()
+		if filename == "" {
+			if limit < 0 || valueBytes < limit {
+				limit = valueBytes
+			}
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, io.LimitReader(part, limit+1))
+			if err != nil {
+				return err
+			}
+			if n > limit {
+				return fmt.Errorf("%w: field %q", ERROR_FORM_TOO_LARGE, name)
+			}
+			valueBytes -= n
+			total += n
+			form.Value.Add(name, buf.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: filename, Header: part.Header}
+		n, err := storeFile(fh, part, limit, maxMemory)
+		if err != nil {
+			if fh.tmpfile != "" {
+				os.Remove(fh.tmpfile)
+			}
+			if err == ERROR_FORM_TOO_LARGE {
+				return fmt.Errorf("%w: file %q", ERROR_FORM_TOO_LARGE, filename)
+			}
+			return err
+		}
+		if fh.tmpfile == "" {
+			maxMemory -= n
+		}
+		total += n
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+// storeFile keeps the part in memory when it fits in maxMemory, in a temp file otherwise.
+// limit is the most the part may hold, -1 for no limit.
+func storeFile(fh *FileHeader, part io.Reader, limit, maxMemory int64) (int64, error) {
+	src := part
+	if limit >= 0 {
+		src = io.LimitReader(part, limit+1)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(src, max(maxMemory, 0)+1))
+	if err != nil {
+		return 0, err
+	}
+	if limit >= 0 && n > limit {
+		return 0, ERROR_FORM_TOO_LARGE
+	}
+	if n <= maxMemory {
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return n, nil
+	}
+
+	// Too big for memory, spill what we have and stream the rest.
+	tmp, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	fh.tmpfile = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(&buf, src))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if limit >= 0 && size > limit {
+		return 0, ERROR_FORM_TOO_LARGE
+	}
+	fh.Size = size
+	return size, nil
+}
+
+// cleanupList is shared by a request and its WithContext copies, so files created through
+// a copy deep in the middleware chain are still removed by the server.
+type cleanupList struct {
+	mu  sync.Mutex
+	fns []func() error
+}
+
+func (r *Request) onCleanup(fn func() error) {
+	if r.cleanup == nil {
+		r.cleanup = &cleanupList{}
+	}
+	r.cleanup.mu.Lock()
+	r.cleanup.fns = append(r.cleanup.fns, fn)
+	r.cleanup.mu.Unlock()
+}
+
+// Cleanup removes what the request left behind (the temp files of ParseMultipartForm).
+// The server calls it after the handler returned.
+func (r *Request) Cleanup() error {
+	if r.cleanup == nil {
+		return nil
+	}
+	r.cleanup.mu.Lock()
+	fns := r.cleanup.fns
+	r.cleanup.fns = nil
+	r.cleanup.mu.Unlock()
+
+	var errs []error
+	for _, fn := range fns {
+		if err := fn(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -2,6 +2,8 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"strconv"
@@ -36,7 +38,42 @@ type Request struct {
 	RequestLine RequestLine
 	Headers headers.Headers
 	Body []byte
+	// BodyStream reads the body still on the connection, Body is nil then. Only multipart/form-data
+	// bodies are left there, with Limits.StreamMultipart: MultipartReader and ParseMultipartForm read
+	// them as they go instead of the whole upload sitting in memory. It can be read once.
+	BodyStream io.Reader
+	RemoteAddr string // "ip:port" of the client, set by the server
+	TLS *tls.ConnectionState // negotiated TLS state, nil on plain connections
+	ClientIdentity *ClientIdentity // verified client certificate identity, nil without mTLS
+	Form Values // query + urlencoded body, nil until ParseForm
+	PostForm Values // urlencoded body only, nil until ParseForm
+	MultipartForm *MultipartForm // nil until ParseMultipartForm
+	ctx context.Context // request scoped values (trace ids, ...), see Context and WithContext
 	state parserState // 0 for initialized, 1 for done
+	limits Limits
+	headerBytes int // bytes of the request line + headers parsed so far
+	query Values // cached by Query
+	queryErr error
+	cleanup *cleanupList // temp files to remove once the handler returned, see Cleanup
+	rest io.Reader // the stream past the request, see Rest
+	deferBody bool // the body is read once the headers are done, see bodyFromStream
+	stream *bodyReader // behind BodyStream, nil when the body is in Body
+}
+
+// Limits protects the parser from huge requests, zero means unlimited.
+type Limits struct {
+	MaxHeaderBytes int // request line + headers
+	MaxBodyBytes   int
+	// Used by Query and ParseForm, shared by the query string and the body.
+	// Zero means DefaultMaxFormKeys / DefaultMaxFormBytes.
+	MaxFormKeys  int
+	MaxFormBytes int
+	// Used by ParseMultipartForm for one part and for all of them, zero means no limit
+	// (MaxBodyBytes still caps the whole body).
+	MaxPartBytes   int
+	MaxUploadBytes int
+	// StreamMultipart leaves multipart/form-data bodies on the stream, see BodyStream.
+	StreamMultipart bool
 }
 
 var ERROR_PARSING_METHOD_IN_REQUEST_LINE = fmt.Errorf("invalid request line: parsing method")
@@ -44,24 +81,55 @@ var ERROR_PARSING_TARGET_IN_REQUEST_LINE = fmt.Errorf("invalid request line: par
 var ERROR_PARSING_HTTP_VERSION_IN_REQUEST_LINE = fmt.Errorf("invalid request line: parsing HTTP version")
 var ERROR_PARSING_BODY_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length: content-length doesn't match the body size")
 var ERROR_UNEXPECTED_EOF = fmt.Errorf("unexpected end of file")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("request headers too large")
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("request body too large")
+var ERROR_INVALID_METHOD = fmt.Errorf("invalid method")
+var ERROR_UNSUPPORTED_VERSION = fmt.Errorf("Unsupported HTTP Version")
+var ERROR_MALFORMED_CHUNKED = fmt.Errorf("invalid chunked body")
 
 
 
+// Both wrap their sentinel, so callers can still match them with errors.Is.
 func ErrorInvalidMethod(method string) error {
-    return fmt.Errorf("invalid method: %s", method)
+    return fmt.Errorf("%w: %s", ERROR_INVALID_METHOD, method)
 }
 
 func ErrorInvalidVersion(version string) error {
-    return fmt.Errorf("Unsupported HTTP Version: %s", version)
+    return fmt.Errorf("%w: %s", ERROR_UNSUPPORTED_VERSION, version)
+}
+
+// Context is the request's context, context.Background() until one is set.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of the request carrying ctx, same as net/http.
+// Middlewares pass the copy down instead of mutating the request they were given.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
 }
 
 func newRequest() Request {
-	return Request{state: requestStateInitialized}
+	return Request{state: requestStateInitialized, cleanup: &cleanupList{}}
 }
 
 // Read The request, agnostic approach, doesn't care if it is a stream of bytes or a full message.
 // Meaning I can read now from a file or a network stream.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithLimits(reader, Limits{})
+}
+
+// Same as RequestFromReader, but gives up as soon as the request goes over the limits,
+// without waiting for (or buffering) the rest of it.
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
 	/* // OLD LOGIC (ReadAll) - Kept for learning reference -> Implemented for files first, then moved to network streams...
     data, err := io.ReadAll(reader)
     if err != nil && err != io.EOF { return nil, err }
@@ -76,6 +144,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 	
 	// Initialize the request
 	req := newRequest()
+	req.limits = limits
 
 	// store the bytes to be parsed.
 	buf := make([]byte, 0)
@@ -117,15 +186,38 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if numBytesParsed > 0 {
 			buf = buf[numBytesParsed:]
 		}
+
+		// Still in the headers, and what's parsed + what's waiting is already too much.
+		if req.limits.MaxHeaderBytes > 0 && req.state < requestStateParsingBody &&
+			req.headerBytes+len(buf) > req.limits.MaxHeaderBytes {
+			return nil, ERROR_HEADERS_TOO_LARGE
+		}
 	}
 
 	if req.state != requestStateDone {
         return nil, ERROR_UNEXPECTED_EOF
     }
 
+	// The last chunk may hold more than the request, keep it for whoever reads on.
+	req.rest = io.MultiReader(bytes.NewReader(bytes.Clone(buf)), reader)
+	if req.deferBody {
+		if err := req.bodyFromStream(); err != nil {
+			return nil, err
+		}
+	}
     return &req, nil
 }
 
+// Rest reads the stream past the request: the bytes the parser already read ahead
+// (the first frame of a protocol switched to, ...), then the rest of the stream itself.
+// A body left in BodyStream is part of it until read.
+func (r *Request) Rest() io.Reader {
+	if r.rest == nil {
+		return bytes.NewReader(nil)
+	}
+	return r.rest
+}
+
 // The next method didn't work because:
 /*
 the parse function receives the slice p.
@@ -227,6 +319,7 @@ func (r *Request) parseSingle(p []byte) (int, error) {
 		// Success: Update struct and State and return the number of bytes to move the data for the next loop
 		r.RequestLine = *rlp
 		r.state = requestStateParsingHeaders
+		r.headerBytes += numBytesParsed
 		return numBytesParsed, nil
 
 	case requestStateParsingHeaders:
@@ -244,15 +337,34 @@ func (r *Request) parseSingle(p []byte) (int, error) {
 		if numBytesParsed == 0 {
             return 0, nil
         }
+		r.headerBytes += numBytesParsed
+		if r.limits.MaxHeaderBytes > 0 && r.headerBytes > r.limits.MaxHeaderBytes {
+			return 0, ERROR_HEADERS_TOO_LARGE
+		}
 
 		// Will only happen when it reachs the empty line.
 		if done {
+			// Chunked bodies have no length to go by, they are read once the headers are done.
+			if r.chunked() {
+				r.deferBody = true
+				r.state = requestStateDone
+				return numBytesParsed, nil
+			}
+
             // Check if we expect a body
-            _, err := r.Headers.Get([]byte("Content-Length"))
+            cl, err := r.Headers.Get([]byte("Content-Length"))
            	if err != nil {
 				r.state = requestStateDone
 			} else {
+				// Refuse a body over the limit before reading any of it.
+				if contentLength, err := strconv.Atoi(cl); err == nil && r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+					return 0, ERROR_BODY_TOO_LARGE
+				}
                 r.state = requestStateParsingBody
+				if r.streamed() {
+					r.deferBody = true
+					r.state = requestStateDone
+				}
             }
         }
 
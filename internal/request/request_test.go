@@ -1,9 +1,14 @@
 package request
 
 import (
+	"context"
 	"io"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -192,6 +197,365 @@ func TestRequestBody(t *testing.T) {
 	r, err = RequestFromReader(readerNoCL)
 	require.NoError(t, err)
 	assert.Empty(t, r.Body) // Should be empty because CL is missing
+	// What was read past the request is still there for whoever reads on
+	rest, err := io.ReadAll(r.Rest())
+	require.NoError(t, err)
+	assert.Equal(t, "body-that-should-be-ignored", string(rest))
+}
+
+
+
+func TestRequestLimits(t *testing.T) {
+	// 1. Headers within the limit
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	r, err := RequestFromReaderWithLimits(strings.NewReader(raw), Limits{MaxHeaderBytes: len(raw)})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", r.Headers["host"])
+
+	// 2. Headers over the limit, even in tiny chunks
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 200) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 64})
+	require.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// 3. Body over the limit is refused from the Content-Length alone
+	_, err = RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 1000\r\n\r\nabc"), Limits{MaxBodyBytes: 10})
+	require.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	// 4. Body within the limit
+	r, err = RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"), Limits{MaxBodyBytes: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+}
+
+func TestRequestChunkedBody(t *testing.T) {
+	chunked := "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+	// Test: The chunks are joined, extensions and trailers skipped, what follows stays on the stream
+	reader := &chunkReader{
+		data:            chunked + "5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Trailer: t\r\n\r\nNEXT",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, int64(12), r.BodyBytes())
+	rest, err := io.ReadAll(r.Rest())
+	require.NoError(t, err)
+	assert.Equal(t, "NEXT", string(rest))
+
+	// Test: MaxBodyBytes is held as the chunks come
+	_, err = RequestFromReaderWithLimits(strings.NewReader(chunked+"5\r\nhello\r\n1\r\n!\r\n0\r\n\r\n"), Limits{MaxBodyBytes: 5})
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	// Test: Broken framing
+	_, err = RequestFromReader(strings.NewReader(chunked + "zz\r\nhello\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNKED)
+	_, err = RequestFromReader(strings.NewReader(chunked + "5\r\nhelloX\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNKED)
+	_, err = RequestFromReader(strings.NewReader(chunked + "5\r\nhel"))
+	assert.ErrorIs(t, err, ERROR_UNEXPECTED_EOF)
+}
+
+func TestRequestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// 1. No context set yet
+	assert.Equal(t, context.Background(), r.Context())
+
+	// 2. WithContext returns a copy, the original keeps its context
+	type key struct{}
+	r2 := r.WithContext(context.WithValue(context.Background(), key{}, "v"))
+	assert.Equal(t, "v", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
 }
 
+func TestEvaluatePreconditions(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC) // sub-second parts are ignored
+	before := "Wed, 01 May 2024 11:00:00 GMT"
+	same := "Wed, 01 May 2024 12:00:00 GMT"
+
+	tests := []struct {
+		method  string
+		headers string
+		want    Precondition
+	}{
+		{"GET", "", PreconditionPassed},
+		// If-None-Match, weak comparison
+		{"GET", "If-None-Match: \"v1\"\r\n", PreconditionNotModified},
+		{"HEAD", "If-None-Match: \"v0\", W/\"v1\"\r\n", PreconditionNotModified},
+		{"GET", "If-None-Match: *\r\n", PreconditionNotModified},
+		{"GET", "If-None-Match: \"v2\"\r\n", PreconditionPassed},
+		{"PUT", "If-None-Match: *\r\n", PreconditionFailed},
+		// If-Modified-Since, ignored when If-None-Match is there or the method isn't GET/HEAD
+		{"GET", "If-Modified-Since: " + same + "\r\n", PreconditionNotModified},
+		{"GET", "If-Modified-Since: " + before + "\r\n", PreconditionPassed},
+		{"GET", "If-Modified-Since: garbage\r\n", PreconditionPassed},
+		{"GET", "If-None-Match: \"v2\"\r\nIf-Modified-Since: " + same + "\r\n", PreconditionPassed},
+		{"POST", "If-Modified-Since: " + same + "\r\n", PreconditionPassed},
+		// If-Match, strong comparison, and If-Unmodified-Since
+		{"PUT", "If-Match: \"v1\"\r\n", PreconditionPassed},
+		{"PUT", "If-Match: W/\"v1\"\r\n", PreconditionFailed},
+		{"PUT", "If-Match: \"v0\"\r\n", PreconditionFailed},
+		{"PUT", "If-Match: *\r\n", PreconditionPassed},
+		{"DELETE", "If-Unmodified-Since: " + before + "\r\n", PreconditionFailed},
+		{"DELETE", "If-Unmodified-Since: " + same + "\r\n", PreconditionPassed},
+		// If-Match wins over If-Unmodified-Since, and is checked before If-None-Match
+		{"PUT", "If-Match: \"v1\"\r\nIf-Unmodified-Since: " + before + "\r\n", PreconditionPassed},
+		{"GET", "If-Match: \"v0\"\r\nIf-None-Match: \"v1\"\r\n", PreconditionFailed},
+	}
+	for _, tt := range tests {
+		r, err := RequestFromReader(strings.NewReader(tt.method + " / HTTP/1.1\r\n" + tt.headers + "\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, r.EvaluatePreconditions(`"v1"`, modified), "%s %q", tt.method, tt.headers)
+	}
+
+	// Without validators nothing matches but "*"
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nIf-None-Match: \"v1\"\r\nIf-Modified-Since: " + same + "\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, PreconditionPassed, r.EvaluatePreconditions("", time.Time{}))
+}
+
+func TestQueryAndForm(t *testing.T) {
+	// Test: Query decoding, "+" and %XX, repeated and empty keys
+	r, err := RequestFromReader(strings.NewReader("GET /search?q=go+lang%21&tag=a&tag=b&empty&x=1%3D2#top HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	q, err := r.Query()
+	require.NoError(t, err)
+	assert.Equal(t, "go lang!", q.Get("q"))
+	assert.Equal(t, []string{"a", "b"}, q["tag"])
+	assert.True(t, q.Has("empty"))
+	assert.Equal(t, "", q.Get("empty"))
+	assert.Equal(t, "1=2", q.Get("x"))
+	assert.False(t, q.Has("missing"))
 
+	// Test: A bad escape is an invalid form
+	r, err = RequestFromReader(strings.NewReader("GET /?q=100%&x=%zz HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.Query()
+	assert.ErrorIs(t, err, ERROR_INVALID_FORM)
+	assert.ErrorIs(t, r.ParseForm(), ERROR_INVALID_FORM)
+
+	// Test: urlencoded body, body values before the query ones
+	body := "name=Ada+Lovelace&lang=en&lang=fr"
+	r, err = RequestFromReader(strings.NewReader("POST /signup?lang=de HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nContent-Length: 33\r\n\r\n" + body))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "Ada Lovelace", r.FormValue("name"))
+	assert.Equal(t, []string{"en", "fr", "de"}, r.Form["lang"])
+	assert.Equal(t, []string{"en", "fr"}, r.PostForm["lang"])
+
+	// Test: Other content types and GET bodies stay out of PostForm
+	r, err = RequestFromReader(strings.NewReader("POST /?a=1 HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: 3\r\n\r\nb=2"))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Empty(t, r.PostForm)
+	assert.Equal(t, "1", r.FormValue("a"))
+	assert.False(t, r.Form.Has("b"))
+
+	// Test: Limits on keys and bytes
+	r, err = RequestFromReaderWithLimits(strings.NewReader("GET /?a=1&b=2&c=3 HTTP/1.1\r\n\r\n"), Limits{MaxFormKeys: 2})
+	require.NoError(t, err)
+	_, err = r.Query()
+	assert.ErrorIs(t, err, ERROR_FORM_TOO_MANY_KEYS)
+	assert.ErrorIs(t, err, ERROR_INVALID_FORM)
+
+	r, err = RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 11\r\n\r\nnote=abcdef"), Limits{MaxFormBytes: 8})
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ParseForm(), ERROR_FORM_TOO_LARGE)
+	assert.Nil(t, r.Form)
+
+	// Test: The query string and the body share one budget
+	post := func(target, body string, limits Limits) *Request {
+		raw := "POST " + target + " HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: " +
+			strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		r, err := RequestFromReaderWithLimits(strings.NewReader(raw), limits)
+		require.NoError(t, err)
+		return r
+	}
+	assert.ErrorIs(t, post("/?a=1&b=2", "c=3&d=4", Limits{MaxFormKeys: 3}).ParseForm(), ERROR_FORM_TOO_MANY_KEYS)
+	assert.NoError(t, post("/?a=1&b=2", "c=3", Limits{MaxFormKeys: 3}).ParseForm())
+	assert.ErrorIs(t, post("/?a=1234", "b=12", Limits{MaxFormBytes: 8}).ParseForm(), ERROR_FORM_TOO_LARGE)
+	assert.NoError(t, post("/?a=12", "b=12", Limits{MaxFormBytes: 8}).ParseForm())
+}
+
+func multipartRequest(t *testing.T, body string, limits Limits) *Request {
+	t.Helper()
+	raw := "POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=XyZ\r\nContent-Length: " +
+		strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	r, err := RequestFromReaderWithLimits(strings.NewReader(raw), limits)
+	require.NoError(t, err)
+	return r
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := "preamble\r\n" +
+		"--XyZ\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nQ3 report\r\n" +
+		"--XyZ\r\nContent-Disposition: form-data; name=\"data\"; filename=\"C:\\\\Users\\\\ada\\\\q3.csv\"\r\nContent-Type: text/csv\r\n\r\na,b\r\n--XyZZ,\r\n1,2\r\n" +
+		"--XyZ--\r\nepilogue"
+	r := multipartRequest(t, body, Limits{})
+
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	// Test: Fields and files, with their headers
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Equal(t, "", part.FileName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "Q3 report", string(data))
+
+	// Test: Almost-delimiters in the content stay content, the directory of the filename goes
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "data", part.FormName())
+	assert.Equal(t, "q3.csv", part.FileName())
+	contentType, _ := part.Header.Get([]byte("Content-Type"))
+	assert.Equal(t, "text/csv", contentType)
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "a,b\r\n--XyZZ,\r\n1,2", string(data))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Not multipart, or cut short
+	plain, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Type: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = plain.MultipartReader()
+	assert.ErrorIs(t, err, ERROR_NOT_MULTIPART)
+
+	r = multipartRequest(t, "--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nno end", Limits{})
+	mr, err = r.MultipartReader()
+	require.NoError(t, err)
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_MULTIPART)
+	assert.ErrorIs(t, err, ERROR_INVALID_FORM)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	big := strings.Repeat("x", 5000) // over the part reader's buffer too
+	body := "--XyZ\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nQ3\r\n" +
+		"--XyZ\r\nContent-Disposition: form-data; name=\"small\"; filename=\"s.csv\"\r\n\r\n1,2\r\n" +
+		"--XyZ\r\nContent-Disposition: form-data; name=\"big\"; filename=\"b.csv\"\r\n\r\n" + big + "\r\n" +
+		"--XyZ--\r\n"
+
+	// Test: Small files stay in memory, the others spill to a temp file
+	r := multipartRequest(t, body, Limits{})
+	copied := r.WithContext(context.Background()) // like a middleware would pass down
+	require.NoError(t, copied.ParseMultipartForm(100))
+	assert.Equal(t, "Q3", copied.FormValue("title"))
+	assert.Equal(t, "Q3", copied.MultipartForm.Value.Get("title"))
+
+	small := copied.MultipartForm.File["small"][0]
+	assert.Equal(t, "s.csv", small.Filename)
+	assert.Equal(t, int64(3), small.Size)
+	assert.Empty(t, small.tmpfile)
+
+	bigFile := copied.MultipartForm.File["big"][0]
+	assert.Equal(t, int64(5000), bigFile.Size)
+	require.NotEmpty(t, bigFile.tmpfile)
+	f, err := bigFile.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, big, string(data))
+	require.NoError(t, f.Close())
+
+	// Test: The original request removes the temp file of its copy
+	require.NoError(t, r.Cleanup())
+	_, err = os.Stat(bigFile.tmpfile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: Per part and total limits
+	r = multipartRequest(t, body, Limits{MaxPartBytes: 4000})
+	assert.ErrorIs(t, r.ParseMultipartForm(100), ERROR_FORM_TOO_LARGE)
+	assert.Nil(t, r.MultipartForm)
+	r = multipartRequest(t, body, Limits{MaxUploadBytes: 5003})
+	assert.ErrorIs(t, r.ParseMultipartForm(100), ERROR_FORM_TOO_LARGE)
+	r = multipartRequest(t, body, Limits{MaxUploadBytes: 5005})
+	require.NoError(t, r.ParseMultipartForm(0))
+	r = multipartRequest(t, body, Limits{MaxFormKeys: 2})
+	assert.ErrorIs(t, r.ParseMultipartForm(0), ERROR_FORM_TOO_MANY_KEYS)
+}
+
+// byteSource is an endless stream of the same byte.
+type byteSource byte
+
+func (b byteSource) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+// countingSource counts what was read from it.
+type countingSource struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingSource) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestStreamedMultipart(t *testing.T) {
+	const size = 16 << 20
+	prefix := "--XyZ\r\nContent-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n"
+	suffix := "\r\n--XyZ--\r\n"
+	length := len(prefix) + size + len(suffix)
+	body := &countingSource{r: io.MultiReader(strings.NewReader(prefix), io.LimitReader(byteSource('x'), size), strings.NewReader(suffix))}
+	head := "POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=XyZ\r\nContent-Length: " + strconv.Itoa(length) + "\r\n\r\n"
+
+	// Test: The parser leaves the body on the stream
+	r, err := RequestFromReaderWithLimits(io.MultiReader(strings.NewReader(head), body), Limits{StreamMultipart: true})
+	require.NoError(t, err)
+	assert.Nil(t, r.Body)
+	require.NotNil(t, r.BodyStream)
+	assert.LessOrEqual(t, body.n, int64(1024))
+	assert.True(t, r.BodyUnread())
+
+	// Test: An upload far over maxMemory goes to disk without passing through memory
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	require.NoError(t, r.ParseMultipartForm(1<<10))
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(2<<20), "allocated while parsing a %d bytes upload", size)
+	defer r.Cleanup()
+
+	file := r.MultipartForm.File["big"][0]
+	assert.Equal(t, int64(size), file.Size)
+	require.NotEmpty(t, file.tmpfile)
+	info, err := os.Stat(file.tmpfile)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), info.Size())
+	assert.Equal(t, int64(length), r.BodyBytes())
+	assert.False(t, r.BodyUnread())
+
+	// Test: Other bodies are still read up front
+	r = multipartRequest(t, prefix+"small"+suffix, Limits{})
+	assert.Nil(t, r.BodyStream)
+	assert.NotEmpty(t, r.Body)
+}
+
+func TestRequestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nCookie: sid=abc; theme=dark\r\nCookie: sid=other\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Len(t, r.Cookies(), 3)
+	c, err := r.Cookie("sid")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", c.Value)
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ERROR_NO_COOKIE)
+}
@@ -0,0 +1,25 @@
+package request
+
+import "context"
+
+// RequestIDHeader carries the id of a request, in both directions.
+// The server reads it back from the response headers for its access log and panic reports.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID stores the request id, see the RequestID middleware.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext is the id of the request, empty when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ID is a shortcut for RequestIDFromContext(r.Context()), handy in log lines.
+func (r *Request) ID() string {
+	return RequestIDFromContext(r.Context())
+}
@@ -0,0 +1,145 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WrapBody puts a filter on the body: fn gets the writer the filtered bytes must go to and returns
+// the one the handler's bytes go through. Close is called when the response is finished, so the
+// filter can write what it still holds. Must be called before commit (an OnCommit hook is fine),
+// the last filter added sees the handler's bytes first.
+//
+// A filter changes the length of the body: in BufferBody mode the Content-Length is computed after
+// filtering, otherwise drop it and set Transfer-Encoding: chunked.
+func (w *Writer) WrapBody(fn func(io.Writer) io.WriteCloser) error {
+	if w.committed {
+		return ErrCommitted
+	}
+	w.filters = append(w.filters, fn)
+	return nil
+}
+
+// setupBody builds the chain the body goes through once the headers are known.
+func (w *Writer) setupBody() {
+	var out io.Writer = w.writer
+
+	te, _ := w.header.Get([]byte("Transfer-Encoding"))
+	if te == "chunked" && bodyAllowed(w.statusCode) {
+		w.chunked = true
+		delete(w.header, "content-length") // never both (RFC 9112 section 6.3)
+		out = &chunkWriter{w: w.writer}
+	} else if cl, err := w.header.Get([]byte("Content-Length")); err == nil && bodyAllowed(w.statusCode) && !w.head {
+		// A handler streaming its own Content-Length must send exactly that many bytes,
+		// one more and the client reads it as the start of the next response.
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.length = &lengthWriter{w: w.writer, remaining: n}
+			out = w.length
+		}
+	}
+
+	for _, fn := range w.filters {
+		wc := fn(out)
+		w.closers = append([]io.Closer{wc}, w.closers...)
+		out = wc
+	}
+	w.filters = nil
+	w.out = out
+}
+
+// filterBufferedBody runs the whole buffered body through the filters, in memory.
+func (w *Writer) filterBufferedBody() error {
+	if len(w.filters) == 0 {
+		return nil
+	}
+
+	var filtered bytes.Buffer
+	var out io.Writer = &filtered
+	var closers []io.Closer
+	for _, fn := range w.filters {
+		wc := fn(out)
+		closers = append([]io.Closer{wc}, closers...)
+		out = wc
+	}
+	w.filters = nil
+
+	if _, err := w.body.WriteTo(out); err != nil {
+		return err
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	w.body = filtered
+	return nil
+}
+
+// Close finishes the response: flushes it, lets the filters write their tail and ends the chunked
+// body. The server calls it once the handler returns, nothing can be written after it.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+
+	for _, c := range w.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if w.chunked {
+		// last chunk, and no trailers
+		if _, err := io.WriteString(w.writer, "0\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	if w.length != nil && w.length.remaining > 0 {
+		// The client waits for the missing bytes, only closing the connection tells it they won't come.
+		return fmt.Errorf("%w: %d bytes missing", ErrContentLengthShort, w.length.remaining)
+	}
+	return nil
+}
+
+// lengthWriter lets through the number of bytes announced by Content-Length, and no more.
+type lengthWriter struct {
+	w io.Writer
+	remaining int64
+}
+
+func (l *lengthWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrContentLengthExceeded
+	}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// chunkWriter frames every write as one chunk of a chunked body (RFC 9112 section 7.1).
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil // an empty chunk would end the body
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(c.w, "\r\n")
+	return n, err
+}
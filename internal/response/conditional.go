@@ -0,0 +1,64 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"boot.mossad.http/internal/headers"
+	"boot.mossad.http/internal/request"
+)
+
+// StrongETag is a strong entity tag for these exact bytes.
+func StrongETag(body []byte) string {
+	return `"` + hashBody(body) + `"`
+}
+
+// WeakETag is a weak entity tag for the body, for content that is "the same" without being
+// byte for byte identical (e.g. before/after compression).
+func WeakETag(body []byte) string {
+	return `W/"` + hashBody(body) + `"`
+}
+
+// 16 bytes of sha256 is plenty to tell two versions of a resource apart.
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// CheckPreconditions sets the ETag and Last-Modified headers (when given), evaluates the conditional
+// headers of the request and answers 304 or 412 when they say so. Returns true when it answered,
+// the handler is done then. Call it before writing anything:
+//
+//	if response.CheckPreconditions(w, req, etag, updatedAt) {
+//		return
+//	}
+func CheckPreconditions(w *Writer, req *request.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(headers.TimeFormat))
+	}
+
+	switch req.EvaluatePreconditions(etag, lastModified) {
+	case request.PreconditionNotModified:
+		NotModified(w)
+		return true
+	case request.PreconditionFailed:
+		Error(w, StatusPreconditionFailed, "precondition failed\n")
+		return true
+	}
+	return false
+}
+
+// NotModified answers 304, keeping the validators and caching headers but none of the body ones.
+// Works from an OnCommit hook too, a buffered body is dropped.
+func NotModified(w *Writer) {
+	h := w.Header()
+	for _, name := range []string{"content-type", "content-length", "content-encoding", "content-range", "transfer-encoding"} {
+		delete(h, name)
+	}
+	w.DiscardBody()
+	w.SetStatus(StatusNotModified)
+}
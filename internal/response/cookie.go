@@ -0,0 +1,30 @@
+package response
+
+import "boot.mossad.http/internal/cookie"
+
+// SetCookie adds a Set-Cookie line to the response, after checking the cookie with Valid.
+// Setting a cookie with the same name, path and domain again replaces it, so a middleware
+// can still change its mind in an OnCommit hook.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.committed {
+		return ErrCommitted
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+
+	cp := *c
+	for i, old := range w.cookies {
+		if old.Name == c.Name && old.Path == c.Path && old.Domain == c.Domain {
+			w.cookies[i] = &cp
+			return nil
+		}
+	}
+	w.cookies = append(w.cookies, &cp)
+	return nil
+}
+
+// Cookies are the cookies set so far, in order.
+func (w *Writer) Cookies() []*cookie.Cookie {
+	return w.cookies
+}
@@ -12,24 +12,57 @@ type StatusCode int
 
 const (
 	StatusOK StatusCode = 200
+	StatusNoContent StatusCode = 204
+	StatusPartialContent StatusCode = 206
+	StatusMovedPermanently StatusCode = 301
+	StatusNotModified StatusCode = 304
 	StatusBadRequest StatusCode = 400
+	StatusUnauthorized StatusCode = 401
+	StatusForbidden StatusCode = 403
+	StatusNotFound StatusCode = 404
+	StatusMethodNotAllowed StatusCode = 405
+	StatusRequestTimeout StatusCode = 408
+	StatusPreconditionFailed StatusCode = 412
+	StatusContentTooLarge StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable StatusCode = 416
+	StatusTooManyRequests StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
 	StatusInternalServerError StatusCode = 500
+	StatusServiceUnavailable StatusCode = 503
 )
 
+// Reason phrases, the switch was getting too long once more codes showed up.
+var statusText = map[StatusCode]string{
+	StatusOK:                  "OK",
+	StatusNoContent:           "No Content",
+	StatusPartialContent:      "Partial Content",
+	StatusMovedPermanently:    "Moved Permanently",
+	StatusNotModified:         "Not Modified",
+	StatusBadRequest:          "Bad Request",
+	StatusUnauthorized:        "Unauthorized",
+	StatusForbidden:           "Forbidden",
+	StatusNotFound:            "Not Found",
+	StatusMethodNotAllowed:    "Method Not Allowed",
+	StatusRequestTimeout:      "Request Timeout",
+	StatusPreconditionFailed:  "Precondition Failed",
+	StatusContentTooLarge:     "Content Too Large",
+	StatusUnsupportedMediaType: "Unsupported Media Type",
+	StatusRangeNotSatisfiable: "Range Not Satisfiable",
+	StatusTooManyRequests:     "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalServerError: "Internal Server Error",
+	StatusServiceUnavailable:  "Service Unavailable",
+}
+
+// StatusText returns the reason phrase of the code, empty for unknown codes.
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	var statusLine string
-
-	switch statusCode {
-	case StatusOK:
-		statusLine = "HTTP/1.1 200 OK\r\n"
-	case StatusBadRequest:
-		statusLine = "HTTP/1.1 400 Bad Request\r\n"
-	case StatusInternalServerError:
-		statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
-	default:
-		// for unknown codes, Leave reason phrase blank
-		statusLine = fmt.Sprintf("HTTP/1.1 %d \r\n", statusCode)
-	}
+	// for unknown codes, the reason phrase is left blank, but the space after the code stays.
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
 
 	_, err := w.Write([]byte(statusLine))
 	return err
@@ -37,9 +70,9 @@ func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
-	h["Content-Length"] = fmt.Sprintf("%d", contentLen)
-	h["Connection"] = "close"
-	h["Content-Type"] = "text/plain"
+	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/plain")
 
 	/* 
 	a few more noteworthy mentions that we won't care about for now are:
@@ -64,3 +97,17 @@ func WriteHeaders(w io.Writer, headers headers.Headers) error {
 	return nil
 }
 
+
+// Error replies with a plain text message, keeping whatever was already staged in w.Header().
+func Error(w *Writer, statusCode StatusCode, message string) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(message)))
+	w.Header().Set("Connection", "close")
+
+	if err := w.WriteHeader(statusCode); err != nil {
+		return err
+	}
+
+	_, err := w.WriteBody([]byte(message))
+	return err
+}
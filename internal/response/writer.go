@@ -1,13 +1,27 @@
 package response
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"time"
 
+	"boot.mossad.http/internal/cookie"
 	"boot.mossad.http/internal/headers"
 )
 
 
+var ErrHijacked = fmt.Errorf("connection has been hijacked")
+var ErrNotHijackable = fmt.Errorf("underlying writer is not a connection")
+var ErrBodyNotAllowed = fmt.Errorf("response status does not allow a body")
+var ErrCommitted = fmt.Errorf("headers already committed")
+var ErrClosed = fmt.Errorf("response already finished")
+var ErrContentLengthExceeded = fmt.Errorf("body longer than the Content-Length already sent")
+var ErrContentLengthShort = fmt.Errorf("body shorter than the Content-Length sent")
+
 type WriterState int
 
 const (
@@ -17,16 +31,62 @@ const (
 )
 
 
+// Writer used to write every piece straight into the connection, one syscall per line.
+// Now everything goes through a bufio.Writer, and the status line + headers are only
+// committed (serialized into the buffer) on the first body write or on Flush.
+// Until then, the headers live in a mutable map (like net/http's Header()).
 type Writer struct {
-	writer io.Writer
+	writer *bufio.Writer
+	raw io.Writer // the connection itself, handed over by Hijack
+	rest io.Reader // what Hijack reads from, the raw connection unless SetHijackReader was called
 	state WriterState
+	hijacked bool
+
+	statusCode StatusCode
+	header headers.Headers
+	committed bool // status line and headers were serialized
+	bytesWritten int // body bytes accepted so far
+
+	// bufferBody holds the whole body in memory until Flush, so Content-Length can be computed.
+	bufferBody bool
+	body bytes.Buffer
+	head bool // answering a HEAD request: the headers describe a body that is never sent
+
+	onCommit []func() // run once, right before the headers are serialized
+	cookies []*cookie.Cookie // one Set-Cookie line each, they can't be comma joined like the other headers
+
+	// The body goes through the filters (compression, ...), then the chunked framing, then the buffer.
+	filters []func(io.Writer) io.WriteCloser
+	out io.Writer // head of that chain, set on commit
+	length *lengthWriter // counts the body against the Content-Length sent, nil without one
+	closers []io.Closer // the filters, outermost first
+	chunked bool // Transfer-Encoding: chunked was committed
+	closed bool
 }
 
 func NewWriter (w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
+		writer: bufio.NewWriter(w),
+		raw: w,
 		state: StateStatusPending,
+		statusCode: StatusOK,
+		header: headers.NewHeaders(),
+	}
+}
+
+// Header returns the staged headers, they can be modified until the headers are committed.
+func (w *Writer) Header() headers.Headers {
+	return w.header
+}
+
+// BufferBody switches the writer into "whole body" mode, the body is kept in memory and
+// the Content-Length header is computed on Flush. Must be called before the first body write.
+func (w *Writer) BufferBody() error {
+	if w.committed {
+		return fmt.Errorf("cannot buffer body: headers already committed")
 	}
+	w.bufferBody = true
+	return nil
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
@@ -34,38 +94,275 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("cannot write status line: current state is %v", w.state)
 	}
 
-	if err := WriteStatusLine(w.writer, statusCode); err != nil {
+	w.statusCode = statusCode
+	w.state = StateHeadersPending
+	return nil
+}
+
+// WriteHeader records the status code and closes the headers, the staged Header() map
+// is what will be sent. Same idea as net/http's WriteHeader.
+func (w *Writer) WriteHeader(statusCode StatusCode) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
 		return err
 	}
 
-	w.state = StateHeadersPending
+	w.state = StateBodyPending
 	return nil
 }
 
-
+// WriteHeaders merges the given headers into the staged ones.
+// Nothing touches the wire until the first body write or Flush.
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.state != StateHeadersPending {
 		return fmt.Errorf("cannot write header line: current state is %v", w.state)
 	}
 
-	if err := WriteHeaders(w.writer, headers); err != nil {
+	for k, v := range headers {
+		w.header.Set(k, v)
+	}
+
+	w.state = StateBodyPending
+	return nil
+}
+
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	// Writing a body without a status line means 200 OK, like net/http.
+	if w.state == StateStatusPending {
+		if err := w.WriteHeader(StatusOK); err != nil {
+			return 0, err
+		}
+	}
+	w.state = StateBodyPending
+
+	if w.bufferBody {
+		n, err := w.body.Write(p)
+		w.bytesWritten += n
+		return n, err
+	}
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+
+	if !bodyAllowed(w.statusCode) && len(p) > 0 {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.head {
+		return len(p), nil
+	}
+
+	n, err := w.out.Write(p)
+	w.bytesWritten += n
+	return n, err
+}
+
+// Write is WriteBody, so the writer can be handed to io.Copy, fmt.Fprintf, json.NewEncoder, ...
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// Flush commits the headers if they weren't yet and pushes the buffered bytes to the connection.
+// In BufferBody mode, this is where Content-Length gets computed and the whole body is written.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+
+	if w.state == StateStatusPending {
+		if err := w.WriteHeader(StatusOK); err != nil {
+			return err
+		}
+	}
+	w.state = StateBodyPending
+
+	if w.bufferBody {
+		// The hooks run first, they may still change the status, drop the body or add filters.
+		w.runCommitHooks()
+		if bodyAllowed(w.statusCode) {
+			// The whole body is known, so it is filtered right here and sent with its real length.
+			if err := w.filterBufferedBody(); err != nil {
+				return err
+			}
+			w.header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+		} else {
+			w.body.Reset()
+			w.filters = nil
+		}
+		delete(w.header, "transfer-encoding")
+		if err := w.commit(); err != nil {
+			return err
+		}
+		// From here on the length is on the wire, w.out refuses anything past it.
+		w.bufferBody = false
+		if w.head {
+			w.body.Reset()
+		} else if _, err := w.body.WriteTo(w.out); err != nil {
+			return err
+		}
+	}
+
+	if err := w.commit(); err != nil {
 		return err
 	}
 
-	if _, err := w.writer.Write([]byte("\r\n")); err != nil {
+	// Push what the filters hold (a gzip block, ...) so streamed responses really go out.
+	for _, c := range w.closers {
+		if f, ok := c.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.writer.Flush()
+}
+
+// commit serializes the status line and the staged headers into the buffer, only once.
+func (w *Writer) commit() error {
+	if w.committed {
+		return nil
+	}
+	w.runCommitHooks()
+	w.committed = true
+	w.setupBody()
+
+	if err := WriteStatusLine(w.writer, w.statusCode); err != nil {
 		return err
 	}
 
-	w.state = StateBodyPending
+	if err := WriteHeaders(w.writer, w.header); err != nil {
+		return err
+	}
+	for _, c := range w.cookies {
+		if _, err := fmt.Fprintf(w.writer, "set-cookie: %s\r\n", c.String()); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.writer.Write([]byte("\r\n"))
+	return err
+}
+
+// OnCommit registers fn to run right before the status line and headers are serialized, the last
+// chance to change them (SetStatus, Header(), WrapBody). In BufferBody mode the whole body is known
+// by then, see BufferedBody. Like defers, the last hook added runs first: the innermost middleware,
+// the closest to the handler, gets the first say.
+func (w *Writer) OnCommit(fn func()) {
+	w.onCommit = append(w.onCommit, fn)
+}
+
+func (w *Writer) runCommitHooks() {
+	// A hook may write to the writer itself, make sure nobody runs twice.
+	hooks := w.onCommit
+	w.onCommit = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// SetStatus replaces the status code as long as nothing was committed, unlike WriteHeader
+// it works after a body was written in BufferBody mode (used by the OnCommit hooks).
+func (w *Writer) SetStatus(statusCode StatusCode) error {
+	if w.committed {
+		return ErrCommitted
+	}
+	w.statusCode = statusCode
+	if w.state == StateStatusPending {
+		w.state = StateBodyPending
+	}
 	return nil
 }
 
+// HeadResponse marks the response as the answer to a HEAD request: the headers (Content-Length
+// included) are the ones a GET would get, the body written by the handler is dropped.
+// The server calls it before the handler runs.
+func (w *Writer) HeadResponse() {
+	w.head = true
+}
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state != StateBodyPending {
-		return 0, fmt.Errorf("cannot write body: headers not written yet")
+// Buffered reports whether the body is being held in memory (BufferBody mode, before Flush).
+func (w *Writer) Buffered() bool {
+	return w.bufferBody
+}
+
+// BufferedBody is the body held in BufferBody mode, not sent yet. Don't keep it around, it is reused.
+func (w *Writer) BufferedBody() []byte {
+	return w.body.Bytes()
+}
+
+// DiscardBody drops the body held in BufferBody mode, to replace it or send none.
+func (w *Writer) DiscardBody() {
+	w.body.Reset()
+}
+
+// bodyAllowed is false for the statuses that never have a body (RFC 9110 section 6.4.1).
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusNoContent && statusCode != StatusNotModified
+}
+
+// SetHijackReader sets where the reader returned by Hijack reads from: the bytes the server read past
+// the request, then the connection. The server calls it, see request.Rest.
+func (w *Writer) SetHijackReader(r io.Reader) {
+	w.rest = r
+}
+
+// Hijack hands the raw connection over to the handler (websockets, tunnels, ...).
+// Whatever was already written is flushed first, staged headers that were never committed are dropped.
+// After this the writer refuses every write and the server won't touch (or close) the connection.
+// The server's read and write deadlines are cleared, the new owner sets its own.
+//
+// Like net/http, the bytes the client sent right behind the request (the first websocket frame, ...)
+// may already have been read by the server: read through rw, not conn, so they aren't lost.
+// Writes can go to either, rw.Writer must be flushed.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+
+	conn, ok := w.raw.(net.Conn)
+	if !ok {
+		return nil, nil, ErrNotHijackable
 	}
 
-	return w.writer.Write(p)
+	if err := w.writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, nil, err
+	}
+
+	var r io.Reader = conn
+	if w.rest != nil {
+		r = w.rest
+	}
+	w.hijacked = true
+	return conn, bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(conn)), nil
+}
+
+// StatusCode is the status sent (or to be sent), 200 until one is written.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
 }
 
+// BytesWritten is the number of body bytes written so far.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// Committed reports whether the status line and headers were already written out,
+// after that the status can't change anymore.
+func (w *Writer) Committed() bool {
+	return w.committed
+}
+
+// Hijacked reports whether Hijack was called.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
@@ -0,0 +1,209 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"boot.mossad.http/internal/cookie"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingWriter counts how many Write calls reach the "connection".
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func TestWriterBuffering(t *testing.T) {
+	// Test: The classic flow is committed in a single write on Flush
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, conn.writes, "Nothing should reach the connection before Flush")
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, conn.writes)
+	out := conn.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Staged headers can still be changed before the first body write
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.Header().Set("X-Custom", "one")
+	require.NoError(t, w.WriteHeader(StatusBadRequest))
+	w.Header().Set("X-Custom", "two")
+	_, err = w.WriteBody([]byte("oops"))
+	require.NoError(t, err)
+	w.Header().Set("X-Custom", "three") // too late, already committed
+	require.NoError(t, w.Flush())
+	out = conn.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "x-custom: two\r\n")
+	assert.NotContains(t, out, "three")
+
+	// Test: Body without status line defaults to 200
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	_, err = w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasPrefix(conn.String(), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Status line can't be written twice
+	w = NewWriter(&countingWriter{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteStatusLine(StatusOK))
+}
+
+func TestWriterBufferBody(t *testing.T) {
+	// Test: Content-Length is computed from the whole body
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	require.NoError(t, w.BufferBody())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteBody([]byte("hello "))
+	w.WriteBody([]byte("world"))
+	assert.Equal(t, 0, conn.writes)
+
+	require.NoError(t, w.Flush())
+	out := conn.String()
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	// Test: The length is on the wire, the body can't grow past it
+	_, err := w.WriteBody([]byte("!"))
+	assert.ErrorIs(t, err, ErrContentLengthExceeded)
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\nhello world"))
+
+	// Test: Can't switch to buffering after commit
+	w = NewWriter(&countingWriter{})
+	w.WriteBody([]byte("x"))
+	require.Error(t, w.BufferBody())
+}
+
+func TestWriterContentLength(t *testing.T) {
+	// Test: A streamed body can't run past the Content-Length the handler set
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	w.Header().Set("Content-Length", "5")
+	_, err := w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("lo!"))
+	assert.ErrorIs(t, err, ErrContentLengthExceeded)
+	_, err = w.WriteBody([]byte("lo"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\nhello"))
+
+	// Test: Nor stop short of it, Close reports the missing bytes
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.Header().Set("Content-Length", "10")
+	w.WriteBody([]byte("short"))
+	assert.ErrorIs(t, w.Close(), ErrContentLengthShort)
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\nshort"))
+
+	// Test: A HEAD response keeps the length and sends no body
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.HeadResponse()
+	w.Header().Set("Content-Length", "10")
+	_, err = w.WriteBody([]byte("dropped"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasSuffix(conn.String(), "content-length: 10\r\n\r\n"))
+
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.HeadResponse()
+	w.BufferBody()
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasSuffix(conn.String(), "content-length: 5\r\n\r\n"))
+}
+
+func TestWriterOnCommit(t *testing.T) {
+	// Test: Hooks see the whole buffered body and can still change the status
+	conn := &countingWriter{}
+	w := NewWriter(conn)
+	require.NoError(t, w.BufferBody())
+	calls := 0
+	w.OnCommit(func() {
+		calls++
+		assert.Equal(t, "hello", string(w.BufferedBody()))
+		w.Header().Set("X-Len", "5")
+		w.DiscardBody()
+		w.WriteBody([]byte("bye"))
+		require.NoError(t, w.SetStatus(StatusNotFound))
+	})
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, calls)
+
+	out := conn.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Contains(t, out, "x-len: 5\r\n")
+	assert.Contains(t, out, "content-length: 3\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbye"))
+	assert.ErrorIs(t, w.SetStatus(StatusOK), ErrCommitted)
+
+	// Test: Hooks run on the first body write when streaming
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.OnCommit(func() { w.Header().Set("X-Hook", "yes") })
+	w.WriteBody([]byte("x"))
+	w.Flush()
+	assert.Contains(t, conn.String(), "x-hook: yes\r\n")
+
+	// Test: 304 and 204 never carry a body
+	conn = &countingWriter{}
+	w = NewWriter(conn)
+	w.BufferBody()
+	w.WriteBody([]byte("dropped"))
+	w.SetStatus(StatusNotModified)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", conn.String())
+
+	w = NewWriter(&countingWriter{})
+	w.WriteHeader(StatusNoContent)
+	_, err := w.WriteBody([]byte("nope"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	// Test: Each cookie gets its own line, setting one again replaces it
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "theme", Value: "dark"}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "sid", Value: "old", Path: "/"}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "sid", Value: "abc123", Path: "/", HttpOnly: true}))
+	assert.Len(t, w.Cookies(), 2)
+
+	// Test: Invalid cookies are refused
+	assert.ErrorIs(t, w.SetCookie(&cookie.Cookie{Name: "bad", Value: "a b"}), cookie.ErrInvalidValue)
+
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Contains(t, out.String(), "set-cookie: theme=dark\r\n")
+	assert.Contains(t, out.String(), "set-cookie: sid=abc123; Path=/; HttpOnly\r\n")
+	assert.NotContains(t, out.String(), "old")
+
+	// Test: Too late once committed
+	assert.ErrorIs(t, w.SetCookie(&cookie.Cookie{Name: "late", Value: "1"}), ErrCommitted)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+)
+
+// Config holds the knobs of a server, the zero value is plain HTTP on ":42069" over tcp.
+type Config struct {
+	// Addr to bind, "127.0.0.1:0" lets the kernel pick a free port (read it back with Server.Addr).
+	// For the "unix" network it is the socket path.
+	Addr string
+	// Network is "tcp" (default), "tcp4", "tcp6" or "unix".
+	Network string
+	// UnixSocket options, only used by the "unix" network.
+	UnixSocket UnixSocketOptions
+	// ReusePort opens that many listeners on Addr with SO_REUSEPORT, each with its own accept loop,
+	// so the kernel spreads new connections between them. 0 or 1 is a single plain listener.
+	ReusePort int
+
+	// ReadTimeout bounds the time to read the whole request, WriteTimeout the time to write the response.
+	// Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxHeaderBytes limits the request line + headers, MaxBodyBytes the body. Zero means no limit.
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+	// MaxFormKeys and MaxFormBytes bound req.Query and req.ParseForm, one budget for the query string and the body
+	// together. Zero means the request package defaults.
+	MaxFormKeys  int
+	MaxFormBytes int
+	// MaxPartBytes and MaxUploadBytes bound req.ParseMultipartForm, one part and all of them.
+	// Zero means no limit besides MaxBodyBytes. Multipart bodies aren't read before the handler runs,
+	// it reads them from req.BodyStream: an upload only takes the memory ParseMultipartForm allows.
+	MaxPartBytes   int
+	MaxUploadBytes int
+
+	// MaxConns caps the connections handled at once. Over it, the accept loop waits for a slot
+	// (new connections queue in the kernel backlog), or with ShedLoad they get a 503 right away.
+	// When too many 503/429 are being sent already, the extra connections are closed without one.
+	MaxConns int
+	ShedLoad bool
+	// MaxConnsPerIP caps the connections from one remote IP, the extra ones get a 429.
+	MaxConnsPerIP int
+	// MaxInFlight caps the requests running their handler at once. A request waits up to
+	// InFlightQueueTimeout for a slot (forever when zero, or until the server closes), then gets a 503.
+	MaxInFlight          int
+	InFlightQueueTimeout time.Duration
+	// RetryAfter is sent with the 503/429 answers above, 1s when zero.
+	RetryAfter time.Duration
+
+	// Logger for server errors and handler panics, log.Default() when nil.
+	Logger *log.Logger
+	// AccessLog gets a line per request handled, nothing is logged when nil.
+	AccessLog *log.Logger
+
+	// TLSConfig turns the listener into a TLS one, it needs either Certificates or GetCertificate (see CertStore).
+	TLSConfig *tls.Config
+	// TLSHandshakeTimeout bounds the handshake on its own, ReadTimeout only starts once it is done.
+	// DefaultTLSHandshakeTimeout when zero: a client that never finishes it would hold the connection forever.
+	TLSHandshakeTimeout time.Duration
+
+	// ErrorHandler answers requests that couldn't be parsed, DefaultErrorHandler when nil.
+	ErrorHandler func(w *response.Writer, err error)
+
+	// OnConnect and OnDisconnect are called when a connection is accepted and when it is closed.
+	OnConnect    func(conn net.Conn)
+	OnDisconnect func(conn net.Conn)
+	// ConnState is called on every state change of a connection, see ConnState.
+	ConnState func(conn net.Conn, state ConnState)
+}
+
+const defaultAddr = ":42069"
+
+// DefaultTLSHandshakeTimeout is used when Config.TLSHandshakeTimeout is zero.
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// DefaultErrorHandler maps the parsing errors to a status code and replies with its reason phrase.
+// The parser's message stays out of the response, it is about our internals, not the client's business.
+func DefaultErrorHandler(w *response.Writer, err error) {
+	status := ErrorStatus(err)
+	response.Error(w, status, response.StatusText(status)+"\n")
+}
+
+// ErrorStatus picks the status code for an error returned by the request parser.
+func ErrorStatus(err error) response.StatusCode {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, request.ERROR_HEADERS_TOO_LARGE):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ERROR_BODY_TOO_LARGE):
+		return response.StatusContentTooLarge
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.StatusRequestTimeout
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Addr == "" {
+		cfg.Addr = defaultAddr
+	}
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = DefaultErrorHandler
+	}
+	if cfg.TLSHandshakeTimeout == 0 {
+		cfg.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+	return cfg
+}
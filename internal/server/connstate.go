@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnState is where a connection is in its life, same states as net/http.
+type ConnState int
+
+const (
+	StateNew      ConnState = iota // accepted, nothing read yet
+	StateActive                    // reading a request or running the handler
+	StateHijacked                  // taken over by the handler with Writer.Hijack, terminal
+	StateClosed                    // closed, terminal
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateHijacked:
+		return "hijacked"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(c))
+	}
+}
+
+var ErrConnNotFound = fmt.Errorf("connection not found")
+
+// ConnInfo is a snapshot of a live connection.
+type ConnInfo struct {
+	ID         uint64
+	RemoteAddr net.Addr
+	Start      time.Time
+	Requests   int
+	State      ConnState
+}
+
+// connRegistry keeps every live connection, so they can be listed (admin dashboards) or closed.
+type connRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+}
+
+type trackedConn struct {
+	conn net.Conn
+	info ConnInfo
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: make(map[uint64]*trackedConn)}
+}
+
+func (r *connRegistry) add(conn net.Conn) *trackedConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	tc := &trackedConn{
+		conn: conn,
+		info: ConnInfo{ID: r.nextID, RemoteAddr: conn.RemoteAddr(), Start: time.Now(), State: StateNew},
+	}
+	r.conns[tc.info.ID] = tc
+	return tc
+}
+
+// setState updates the state, terminal states remove the connection from the registry.
+func (r *connRegistry) setState(tc *trackedConn, state ConnState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tc.info.State = state
+	if state == StateHijacked || state == StateClosed {
+		delete(r.conns, tc.info.ID)
+	}
+}
+
+func (r *connRegistry) countRequest(tc *trackedConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tc.info.Requests++
+}
+
+// setState moves the connection to a new state and tells Config.ConnState about it.
+func (s *Server) setState(tc *trackedConn, state ConnState) {
+	s.conns.setState(tc, state)
+	if s.cfg.ConnState != nil {
+		s.cfg.ConnState(tc.conn, state)
+	}
+}
+
+// Connections lists the live connections, oldest first.
+func (s *Server) Connections() []ConnInfo {
+	s.conns.mu.Lock()
+	defer s.conns.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(s.conns.conns))
+	for _, tc := range s.conns.conns {
+		infos = append(infos, tc.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// CloseConn closes a live connection by its ID, the handler sees read/write errors.
+func (s *Server) CloseConn(id uint64) error {
+	s.conns.mu.Lock()
+	tc, ok := s.conns.conns[id]
+	s.conns.mu.Unlock()
+
+	if !ok {
+		return ErrConnNotFound
+	}
+	return tc.conn.Close()
+}
+
+// CloseConnections closes every live connection, e.g. once Shutdown's grace period is over.
+func (s *Server) CloseConnections() {
+	for _, info := range s.Connections() {
+		s.CloseConn(info.ID)
+	}
+}
+
+// activeReader flips the connection to StateActive on the first bytes of the request.
+type activeReader struct {
+	conn     net.Conn
+	onActive func()
+	started  bool // some bytes arrived
+}
+
+func (a *activeReader) Read(p []byte) (int, error) {
+	n, err := a.conn.Read(p)
+	if n > 0 && !a.started {
+		a.started = true
+		if a.onActive != nil {
+			a.onActive()
+		}
+	}
+	return n, err
+}
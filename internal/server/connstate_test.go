@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stateRecorder collects the ConnState transitions.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+	closed chan struct{}
+}
+
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{closed: make(chan struct{}, 10)}
+}
+
+func (r *stateRecorder) hook(conn net.Conn, state ConnState) {
+	r.mu.Lock()
+	r.states = append(r.states, state)
+	r.mu.Unlock()
+	if state == StateClosed || state == StateHijacked {
+		r.closed <- struct{}{}
+	}
+}
+
+func (r *stateRecorder) get() []ConnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ConnState(nil), r.states...)
+}
+
+func TestServerConnState(t *testing.T) {
+	rec := newStateRecorder()
+	s := startServer(t, Config{ConnState: rec.hook}, echoHandler)
+
+	roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	<-rec.closed
+	assert.Equal(t, []ConnState{StateNew, StateActive, StateClosed}, rec.get())
+	assert.Empty(t, s.Connections())
+}
+
+func TestServerConnections(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s := startServer(t, Config{}, blockingHandler(started, release))
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	// Test: The held connection is listed
+	conns := s.Connections()
+	require.Len(t, conns, 1)
+	assert.Equal(t, StateActive, conns[0].State)
+	assert.Equal(t, 1, conns[0].Requests)
+	assert.Equal(t, held.LocalAddr().String(), conns[0].RemoteAddr.String())
+	assert.WithinDuration(t, time.Now(), conns[0].Start, 5*time.Second)
+
+	// Test: Closing it from the server side
+	require.NoError(t, s.CloseConn(conns[0].ID))
+	held.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := held.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	require.ErrorIs(t, s.CloseConn(12345), ErrConnNotFound)
+}
+
+func TestServerHijack(t *testing.T) {
+	rec := newStateRecorder()
+	cfg := Config{ConnState: rec.hook, ReadTimeout: 50 * time.Millisecond, WriteTimeout: 50 * time.Millisecond}
+	s := startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		conn, rw, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		// The server is out of the picture, speak whatever protocol we want.
+		go func() {
+			defer conn.Close()
+			// Past the server's timeouts, they don't apply anymore
+			time.Sleep(100 * time.Millisecond)
+			for i := 0; i < 2; i++ {
+				line, _ := rw.ReadString('\n')
+				rw.WriteString("echo " + line)
+				rw.Flush()
+			}
+		}()
+	})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Test: A frame sent right behind the request, read along with it, reaches the new owner
+	conn.Write([]byte("GET /ws HTTP/1.1\r\n\r\nearly\n"))
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo early\n", line)
+	conn.Write([]byte("ping\n"))
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo ping\n", line)
+
+	<-rec.closed
+	states := rec.get()
+	assert.Equal(t, StateHijacked, states[len(states)-1])
+	assert.Empty(t, s.Connections())
+}
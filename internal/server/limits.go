@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"boot.mossad.http/internal/response"
+)
+
+const defaultRetryAfter = time.Second
+
+// How many refused connections may be getting their 503/429 at once. Each one holds a goroutine and
+// its fd for a moment (write deadline + lingering close), past that a flood is just hung up on.
+const maxConcurrentRejects = 256
+
+// limiter enforces Config.MaxConns, MaxConnsPerIP and MaxInFlight.
+// The slots are buffered channels used as semaphores, nil when the limit is off.
+type limiter struct {
+	conns    chan struct{}
+	inFlight chan struct{}
+	rejects  chan struct{}
+
+	maxPerIP int
+	mu       sync.Mutex
+	perIP    map[string]int
+}
+
+func newLimiter(cfg Config) *limiter {
+	l := &limiter{
+		maxPerIP: cfg.MaxConnsPerIP,
+		perIP:    make(map[string]int),
+		rejects:  make(chan struct{}, maxConcurrentRejects),
+	}
+	if cfg.MaxConns > 0 {
+		l.conns = make(chan struct{}, cfg.MaxConns)
+	}
+	if cfg.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, cfg.MaxInFlight)
+	}
+	return l
+}
+
+// waitConn blocks until a connection slot is free, used before Accept so the extra
+// connections wait in the kernel backlog. Returns false if the server closed meanwhile.
+func (l *limiter) waitConn(closed <-chan struct{}) bool {
+	if l.conns == nil {
+		return true
+	}
+	select {
+	case l.conns <- struct{}{}:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// tryConn takes a connection slot without waiting.
+func (l *limiter) tryConn() bool {
+	if l.conns == nil {
+		return true
+	}
+	select {
+	case l.conns <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) releaseConn() {
+	if l.conns != nil {
+		<-l.conns
+	}
+}
+
+// acquireIP counts one more connection for the remote IP, false when it is over the cap.
+func (l *limiter) acquireIP(ip string) bool {
+	if l.maxPerIP <= 0 || ip == "" {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+func (l *limiter) releaseIP(ip string) {
+	if l.maxPerIP <= 0 || ip == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip) // don't keep every IP ever seen
+	}
+}
+
+// acquireRequest waits up to timeout for an in-flight request slot (forever when timeout is 0).
+// Returns false when the time is up or the server closed meanwhile.
+func (l *limiter) acquireRequest(timeout time.Duration, closed <-chan struct{}) bool {
+	if l.inFlight == nil {
+		return true
+	}
+
+	var expired <-chan time.Time // nil, never fires
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+		return true
+	case <-expired:
+		return false
+	case <-closed:
+		return false
+	}
+}
+
+func (l *limiter) releaseRequest() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// remoteIP is the host part of the remote address, empty for unix sockets.
+func remoteIP(conn net.Conn) string {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return addr.IP.String()
+}
+
+// tryReject takes one of the reject slots without waiting.
+func (l *limiter) tryReject() bool {
+	select {
+	case l.rejects <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) releaseReject() {
+	<-l.rejects
+}
+
+// rejectAsync answers the refused connection in the background, or just closes it when
+// too many are being answered already: shedding must not cost more than serving.
+func (s *Server) rejectAsync(conn net.Conn, statusCode response.StatusCode) {
+	if !s.limits.tryReject() {
+		conn.Close()
+		return
+	}
+	s.goTracked(func() {
+		defer s.limits.releaseReject()
+		s.reject(conn, statusCode)
+	})
+}
+
+// reject answers without reading the request, with a Retry-After hint.
+func (s *Server) reject(conn net.Conn, statusCode response.StatusCode) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+
+	w := response.NewWriter(conn)
+	w.Header().Set("Retry-After", retryAfterSeconds(s.cfg.RetryAfter))
+	response.Error(w, statusCode, response.StatusText(statusCode)+"\n")
+	w.Flush()
+	lingeringClose(conn)
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	if d <= 0 {
+		d = defaultRetryAfter
+	}
+	// Retry-After is in whole seconds, round up so we never ask for 0.
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler holds every request until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		echoHandler(w, req)
+	}
+}
+
+// holdConn opens a connection that stays in the handler until release is closed.
+func holdConn(t *testing.T, addr string, started <-chan struct{}) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET /held HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	return conn
+}
+
+func TestServerMaxConnsShedLoad(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s := startServer(t, Config{MaxConns: 1, ShedLoad: true, RetryAfter: 1500 * time.Millisecond}, blockingHandler(started, release))
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	// Test: Over the limit, answered right away
+	out := roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, out, "retry-after: 2\r\n")
+}
+
+func TestServerMaxConnsBlocking(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	s := startServer(t, Config{MaxConns: 1}, blockingHandler(started, release))
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	result := make(chan string)
+	go func() { result <- roundTrip(t, "tcp", s.Addr().String(), "GET /queued HTTP/1.1\r\n\r\n") }()
+
+	// Test: The second connection waits in the backlog, its handler doesn't start
+	select {
+	case <-started:
+		t.Fatal("second connection should not be handled while the first one holds the slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.True(t, strings.HasSuffix(<-result, "/queued"))
+}
+
+func TestServerMaxConnsPerIP(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s := startServer(t, Config{MaxConnsPerIP: 1}, blockingHandler(started, release))
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	out := roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"))
+	assert.Contains(t, out, "retry-after: 1\r\n")
+}
+
+func TestServerMaxInFlight(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s := startServer(t, Config{MaxInFlight: 1, InFlightQueueTimeout: 20 * time.Millisecond}, blockingHandler(started, release))
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	// Test: Accepted and parsed, but no request slot within the queue timeout
+	out := roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestServerRejectCap(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	s := New(Config{Addr: "127.0.0.1:0", MaxConns: 1, ShedLoad: true}, blockingHandler(started, release))
+	// Every reject slot taken, as if a flood was being answered
+	for i := 0; i < cap(s.limits.rejects); i++ {
+		s.limits.rejects <- struct{}{}
+	}
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	held := holdConn(t, s.Addr().String(), started)
+	defer held.Close()
+
+	// Test: No slot to answer in, the connection is just closed (EOF or reset, never a response)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+	}
+	assert.Empty(t, out)
+}
+
+func TestAcquireRequestClosed(t *testing.T) {
+	l := newLimiter(Config{MaxInFlight: 1})
+	closed := make(chan struct{})
+	require.True(t, l.acquireRequest(0, closed))
+
+	// Test: Waiting forever stops when the server closes
+	result := make(chan bool)
+	go func() { result <- l.acquireRequest(0, closed) }()
+	select {
+	case <-result:
+		t.Fatal("should wait for the slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(closed)
+	assert.False(t, <-result)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnixSocketOptions controls the socket file created for the "unix" network.
+type UnixSocketOptions struct {
+	// Mode of the socket file (e.g. 0660 so nginx's group can connect), 0 keeps the umask default.
+	Mode os.FileMode
+	// Chown the socket to UID/GID, -1 keeps the current value of that one.
+	Chown    bool
+	UID, GID int
+	// RemoveStale deletes a socket file left behind by a crashed process (nobody answering on it).
+	RemoveStale bool
+}
+
+// The first file descriptor passed by systemd, 0-2 are stdin/stdout/stderr.
+// A variable so tests can point it at their own descriptors.
+var listenFDsStart = 3
+
+var ErrSocketInUse = fmt.Errorf("unix socket is in use by another process")
+var ErrNoSystemdListeners = fmt.Errorf("no listeners passed by systemd")
+
+// ListenUnix listens on a Unix domain socket at path.
+func ListenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	if opts.RemoveStale {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	if opts.Chown {
+		if err := os.Chown(path, opts.UID, opts.GID); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
+
+// removeStaleSocket removes path only if it is a socket nobody is listening on,
+// a regular file or a live socket are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+
+	return os.Remove(path)
+}
+
+// SystemdListeners adopts the sockets systemd opened for us (socket activation).
+// It follows sd_listen_fds: LISTEN_PID must be our pid, LISTEN_FDS is how many descriptors start at fd 3.
+// The variables are unset afterwards so child processes don't try to adopt them again.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid == "" || fds == "" {
+		return nil, ErrNoSystemdListeners
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("LISTEN_PID %s is not our pid %d", pid, os.Getpid())
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	return listenersFromFDs(listenFDsStart, n, names)
+}
+
+// listenersFromFDs turns count inherited descriptors starting at start into listeners.
+func listenersFromFDs(start, count int, names []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("fd%d", start+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(start+i), name)
+		// FileListener dups the descriptor, so the original one can be closed right away.
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("inherited %s is not a listener: %w", name, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
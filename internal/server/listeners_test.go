@@ -0,0 +1,106 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	startServer(t, Config{
+		Network:    "unix",
+		Addr:       path,
+		UnixSocket: UnixSocketOptions{Mode: 0660, RemoveStale: true},
+	}, echoHandler)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	out := roundTrip(t, "unix", path, "GET /over-unix HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/over-unix"))
+
+	// Test: A live socket is not treated as stale
+	_, err = ListenUnix(path, UnixSocketOptions{RemoveStale: true})
+	require.ErrorIs(t, err, ErrSocketInUse)
+}
+
+func TestListenUnixStale(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stale.sock")
+
+	// Leave a socket file behind, like a crashed process would.
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	// Test: Without cleanup the address is taken
+	_, err = ListenUnix(path, UnixSocketOptions{})
+	require.Error(t, err)
+
+	// Test: With cleanup it is reused
+	ln, err = ListenUnix(path, UnixSocketOptions{RemoveStale: true})
+	require.NoError(t, err)
+	ln.Close()
+
+	// Test: Regular files are never removed
+	file := filepath.Join(dir, "not-a-socket")
+	require.NoError(t, os.WriteFile(file, []byte("data"), 0644))
+	_, err = ListenUnix(file, UnixSocketOptions{RemoveStale: true})
+	require.Error(t, err)
+	_, err = os.Stat(file)
+	require.NoError(t, err)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// Test: Nothing passed
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	_, err := SystemdListeners()
+	require.ErrorIs(t, err, ErrNoSystemdListeners)
+
+	// Test: Meant for another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	_, err = SystemdListeners()
+	require.Error(t, err)
+
+	// Fake the inherited descriptor with one of our own listeners.
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	f, err := listenerFile(tcp)
+	require.NoError(t, err)
+	defer f.Close()
+
+	old := listenFDsStart
+	listenFDsStart = dupFD(t, f)
+	defer func() { listenFDsStart = old }()
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+
+	listeners, err := SystemdListeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Equal(t, tcp.Addr().String(), listeners[0].Addr().String())
+	assert.Empty(t, os.Getenv("LISTEN_FDS"), "env should be cleared for child processes")
+
+	s := New(Config{}, echoHandler)
+	require.NoError(t, s.Serve(listeners[0]))
+	defer s.Close()
+	out := roundTrip(t, "tcp", tcp.Addr().String(), "GET /activated HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/activated"))
+}
@@ -0,0 +1,13 @@
+package server
+
+// Middleware wraps a handler with extra behaviour (auth, logging, ...).
+type Middleware func(Handler) Handler
+
+// Chain applies the middlewares so the first one is the outermost,
+// Chain(h, a, b) handles a request as a -> b -> h.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
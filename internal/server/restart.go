@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Zero-downtime restart (hot binary upgrade):
+//   1. the parent re-execs its own binary, handing over the listening socket as an extra file,
+//   2. the child adopts the socket, starts accepting and tells the parent it is ready through a pipe,
+//   3. the parent stops accepting and drains its connections with Shutdown.
+// The socket is never closed, so new connections wait in the kernel backlog instead of being refused.
+
+const (
+	envUpgradeFDs     = "UPGRADE_LISTEN_FDS" // number of listeners, starting at fd 3
+	envUpgradeReadyFD = "UPGRADE_READY_FD"   // write end of the readiness pipe
+)
+
+var ErrUpgradeNotReady = fmt.Errorf("upgrade: child exited before becoming ready")
+var ErrNoInheritedListeners = fmt.Errorf("no listeners inherited from a parent process")
+
+// Reexec starts a new copy of the running binary with the same arguments, passing it the listeners.
+// It returns once the child called NotifyUpgradeReady, the caller then drains with Shutdown and exits.
+// If the child dies or doesn't get ready within timeout, it is killed and the parent keeps serving.
+func (s *Server) Reexec(timeout time.Duration) (*os.Process, error) {
+	if len(s.rawListeners) == 0 {
+		return nil, fmt.Errorf("upgrade: server is not listening")
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range s.rawListeners {
+		f, err := listenerFile(ln)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// ExtraFiles[i] becomes fd 3+i in the child: the listeners first, then the pipe.
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		envUpgradeFDs+"="+strconv.Itoa(len(files)),
+		envUpgradeReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+
+	err = cmd.Start()
+	readyW.Close() // the child has its own copy now, we only read
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		// returns EOF if the child exits without writing
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, ErrUpgradeNotReady
+		}
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("upgrade: child not ready after %v", timeout)
+	}
+
+	// The socket file now belongs to the child, our Close must not delete it.
+	for _, ln := range s.rawListeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	return cmd.Process, nil
+}
+
+// InheritedListeners returns the listeners handed over by a parent during an upgrade,
+// or ErrNoInheritedListeners when the process was started normally.
+func InheritedListeners() ([]net.Listener, error) {
+	fds := os.Getenv(envUpgradeFDs)
+	if fds == "" {
+		return nil, ErrNoInheritedListeners
+	}
+	os.Unsetenv(envUpgradeFDs)
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid %s %q", envUpgradeFDs, fds)
+	}
+
+	return listenersFromFDs(listenFDsStart, n, nil)
+}
+
+// NotifyUpgradeReady tells the parent that we are serving, so it can start draining.
+// It does nothing when the process wasn't started by Reexec.
+func NotifyUpgradeReady() error {
+	fdStr := os.Getenv(envUpgradeReadyFD)
+	if fdStr == "" {
+		return nil
+	}
+	os.Unsetenv(envUpgradeReadyFD)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", envUpgradeReadyFD, fdStr)
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// listenerFile dups the listener's descriptor so it can be passed to a child process.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	type filer interface{ File() (*os.File, error) }
+
+	f, ok := ln.(filer)
+	if !ok {
+		return nil, fmt.Errorf("upgrade: %T listener can't be passed to a child process", ln)
+	}
+	return f.File()
+}
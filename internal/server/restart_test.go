@@ -0,0 +1,161 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	// Test: Normal start
+	t.Setenv(envUpgradeFDs, "")
+	_, err := InheritedListeners()
+	require.ErrorIs(t, err, ErrNoInheritedListeners)
+
+	// Fake what Reexec hands over: the listener at "fd 3" and the readiness pipe.
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	f, err := listenerFile(tcp)
+	require.NoError(t, err)
+	defer f.Close()
+
+	old := listenFDsStart
+	listenFDsStart = dupFD(t, f)
+	defer func() { listenFDsStart = old }()
+
+	readyR, readyW, err := os.Pipe()
+	require.NoError(t, err)
+	defer readyR.Close()
+	readyFD := dupFD(t, readyW)
+	readyW.Close()
+
+	t.Setenv(envUpgradeFDs, "1")
+	t.Setenv(envUpgradeReadyFD, strconv.Itoa(readyFD))
+
+	listeners, err := InheritedListeners()
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Equal(t, tcp.Addr().String(), listeners[0].Addr().String())
+	listeners[0].Close()
+
+	// Test: The parent gets its byte
+	require.NoError(t, NotifyUpgradeReady())
+	buf := make([]byte, 1)
+	n, err := readyR.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Test: Not started by Reexec, nothing to do
+	require.NoError(t, NotifyUpgradeReady())
+}
+
+// envReexecHelper makes the test binary run as the child of TestReexec, see TestReexecHelperProcess.
+const envReexecHelper = "REEXEC_HELPER_PROCESS"
+
+func TestReexec(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	s := startServer(t, Config{}, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/held" {
+			started <- struct{}{}
+			<-release
+		}
+		response.Error(w, response.StatusOK, "parent")
+	})
+	addr := s.Addr().String()
+	held := holdConn(t, addr, started)
+	defer held.Close()
+	held.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The child is this test binary again, running only the helper below.
+	t.Setenv(envReexecHelper, "1")
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestReexecHelperProcess$"}
+	child, err := s.Reexec(10 * time.Second)
+	os.Args = args
+	require.NoError(t, err)
+	defer func() {
+		child.Signal(syscall.SIGTERM)
+		child.Wait()
+	}()
+
+	// Test: Once the parent stops accepting, the child answers on the inherited socket
+	require.NoError(t, s.Close())
+	drained := make(chan error, 1)
+	go func() { drained <- s.Shutdown(context.Background()) }()
+	for i := 0; i < 3; i++ {
+		out := roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\nchild "+strconv.Itoa(child.Pid)), out)
+	}
+
+	// Test: Meanwhile the parent drains, the request it was serving still gets its answer
+	select {
+	case <-drained:
+		t.Fatal("Shutdown returned with a request in flight")
+	default:
+	}
+	close(release)
+	out, err := io.ReadAll(held)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nparent"))
+	require.NoError(t, <-drained)
+}
+
+// TestReexecHelperProcess is the child started by TestReexec, it does nothing in a normal run.
+func TestReexecHelperProcess(t *testing.T) {
+	if os.Getenv(envReexecHelper) != "1" {
+		return
+	}
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "reexec helper:", err)
+		os.Exit(2)
+	}
+
+	listeners, err := InheritedListeners()
+	if err != nil {
+		fail(err)
+	}
+	s := New(Config{}, func(w *response.Writer, req *request.Request) {
+		response.Error(w, response.StatusOK, "child "+strconv.Itoa(os.Getpid()))
+	})
+	if err := s.Serve(listeners[0]); err != nil {
+		fail(err)
+	}
+	if err := NotifyUpgradeReady(); err != nil {
+		fail(err)
+	}
+
+	// Serve until the parent test is done with us.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	select {
+	case <-sig:
+	case <-time.After(time.Minute):
+	}
+	s.Shutdown(context.Background())
+	os.Exit(0)
+}
+
+// dupFD returns a raw copy of the descriptor that no *os.File owns,
+// like the descriptors a new process inherits.
+func dupFD(t *testing.T, f *os.File) int {
+	t.Helper()
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fd
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+var ErrReusePortUnsupported = fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+
+// ListenReusePort opens n listeners bound to the same address with SO_REUSEPORT.
+// When the port is 0, the first listener picks it and the others join that same port.
+func ListenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	if reusePortControl == nil {
+		return nil, ErrReusePortUnsupported
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		ln, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			addr = ln.Addr().String()
+		}
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
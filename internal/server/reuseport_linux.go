@@ -0,0 +1,6 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package server
+
+// The frozen syscall package never got SO_REUSEPORT for Linux, this is its value on every arch but mips.
+const soReusePort = 0xf
@@ -0,0 +1,7 @@
+//go:build !((linux && !(mips || mipsle || mips64 || mips64le)) || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package server
+
+import "syscall"
+
+var reusePortControl func(network, address string, c syscall.RawConn) error
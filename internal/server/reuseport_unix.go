@@ -0,0 +1,18 @@
+//go:build (linux && !(mips || mipsle || mips64 || mips64le)) || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package server
+
+import "syscall"
+
+var reusePortControl = setReusePort
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"boot.mossad.http/internal/request"
 	"boot.mossad.http/internal/response"
@@ -14,9 +19,15 @@ import (
 type Handler func(w *response.Writer, req *request.Request)
 
 type Server struct {
-	listener net.Listener
+	cfg Config
+	listeners []net.Listener
+	rawListeners []net.Listener // before the TLS wrapping, what gets handed over on Reexec
 	handler Handler
 	isClosed atomic.Bool
+	closed chan struct{} // closed by Close, wakes up the accept loops waiting for a slot
+	wg sync.WaitGroup // the accept loops + every connection being handled
+	limits *limiter
+	conns *connRegistry
 }
 
 type HandlerError struct {
@@ -24,41 +35,251 @@ type HandlerError struct {
 	Message    string
 }
 
+// New creates a server, nothing is bound until ListenAndServe or Serve is called.
+func New(cfg Config, handler Handler) *Server {
+	cfg = cfg.withDefaults()
+	return &Server{
+		cfg: cfg,
+		handler: handler,
+		closed: make(chan struct{}),
+		limits: newLimiter(cfg),
+		conns: newConnRegistry(),
+	}
+}
+
+// Serve listens on every interface on the port, the old entry point kept for the simple case.
 func Serve(port int, handler Handler) (*Server, error) {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
+	s := New(Config{Addr: fmt.Sprintf(":%d", port)}, handler)
+	if err := s.ListenAndServe(); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
 
-	s := &Server{
-		listener: ln,
-		handler: handler,
+// ServeTLS serves HTTPS using the given certificate, the files are watched and reloaded when they change.
+func ServeTLS(port int, certFile, keyFile string, handler Handler) (*Server, error) {
+	certs := NewCertStore()
+	if err := certs.Add(certFile, keyFile); err != nil {
+		return nil, err
 	}
 
-	go s.listen()
-	
+	s := New(Config{Addr: fmt.Sprintf(":%d", port), TLSConfig: certs.TLSConfig()}, handler)
+	if err := s.ListenAndServe(); err != nil {
+		return nil, err
+	}
 	return s, nil
-	
+}
+
+// ListenAndServe binds Config.Network/Config.Addr and starts accepting in the background.
+// With Config.ReusePort > 1, that many SO_REUSEPORT listeners are opened on the same address.
+func (s *Server) ListenAndServe() error {
+	if s.cfg.ReusePort > 1 {
+		listeners, err := ListenReusePort(s.cfg.Network, s.cfg.Addr, s.cfg.ReusePort)
+		if err != nil {
+			return err
+		}
+		return s.ServeListeners(listeners...)
+	}
+
+	var ln net.Listener
+	var err error
+	if s.cfg.Network == "unix" {
+		ln, err = ListenUnix(s.cfg.Addr, s.cfg.UnixSocket)
+	} else {
+		ln, err = net.Listen(s.cfg.Network, s.cfg.Addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve starts accepting connections from a listener the caller already opened, in the background.
+// The server owns the listener from now on, Close closes it.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.ServeListeners(ln)
+}
+
+// ServeListeners is Serve for several listeners (SO_REUSEPORT, systemd or inherited sockets),
+// each one gets its own accept loop.
+func (s *Server) ServeListeners(listeners ...net.Listener) error {
+	if len(s.listeners) > 0 {
+		return fmt.Errorf("server is already serving on %s", s.listeners[0].Addr())
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listener to serve on")
+	}
+
+	for _, ln := range listeners {
+		s.rawListeners = append(s.rawListeners, ln)
+		if s.cfg.TLSConfig != nil {
+			ln = tls.NewListener(ln, s.cfg.TLSConfig)
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+
+	for _, ln := range s.listeners {
+		s.wg.Add(1)
+		go s.listen(ln)
+	}
+
+	return nil
+}
+
+// Addr is the address the server is bound to, useful to read back the port after binding ":0".
+func (s *Server) Addr() net.Addr {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
 }
 
 func (s *Server) Close() error {
-	 s.isClosed.Store(true)
+	 if s.isClosed.Swap(true) {
+		 return nil // already closed
+	 }
+	 close(s.closed)
+
+	 var firstErr error
+	 for _, ln := range s.listeners {
+		 if err := ln.Close(); err != nil && firstErr == nil {
+			 firstErr = err
+		 }
+	 }
+	 return firstErr
+}
+
+// Shutdown stops accepting new connections and waits for the ones in flight to finish.
+// If ctx ends first, its error is returned and the remaining connections are left running.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
 
-	 return s.listener.Close()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (s *Server) listen() {
+func (s *Server) listen(ln net.Listener) {
+	defer s.wg.Done()
 	for {
-		conn, err := s.listener.Accept()
+		// Blocking mode: don't even accept until a slot is free, the extra connections wait in the backlog.
+		if !s.cfg.ShedLoad && !s.limits.waitConn(s.closed) {
+			return
+		}
+
+		conn, err := ln.Accept()
 		if err != nil {
+			if !s.cfg.ShedLoad {
+				s.limits.releaseConn()
+			}
 			if s.isClosed.Load() {
 				return
 			}
-			log.Printf("Error accepting connection: %v\n", err)
+			s.cfg.Logger.Printf("Error accepting connection: %v\n", err)
+			continue
+		}
+
+		// Shedding mode: over the limit, answer 503 right away instead of queueing.
+		if s.cfg.ShedLoad && !s.limits.tryConn() {
+			s.rejectAsync(conn, response.StatusServiceUnavailable)
 			continue
 		}
-		go s.handle(conn)
+
+		ip := remoteIP(conn)
+		if !s.limits.acquireIP(ip) {
+			s.limits.releaseConn()
+			s.rejectAsync(conn, response.StatusTooManyRequests)
+			continue
+		}
+
+		s.goTracked(func() {
+			defer s.limits.releaseConn()
+			defer s.limits.releaseIP(ip)
+			s.handle(conn)
+		})
+	}
+}
+
+// goTracked runs fn in a goroutine counted by Shutdown.
+// Called from the accept loop, which still counts itself, so Shutdown's Wait can't miss it.
+func (s *Server) goTracked(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
+
+// When a request is refused mid-way, the client may still be sending it. Closing right away with unread
+// bytes makes the kernel answer with a RST, and the client can lose our error response.
+// So stop writing, swallow what's left for a moment, then close.
+func lingeringClose(conn net.Conn) {
+	type closeWriter interface{ CloseWrite() error }
+	cw, ok := conn.(closeWriter)
+	if !ok {
+		return
+	}
+
+	cw.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	io.Copy(io.Discard, io.LimitReader(conn, 256<<10))
+}
+
+// serveRequest runs the handler, a panic is logged with the request id and stack instead of killing the process.
+// Returns false when the handler panicked.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) (ok bool) {
+	// Temp files of uploads go away once the handler is done with them, panic or not.
+	defer func() {
+		if err := req.Cleanup(); err != nil {
+			s.cfg.Logger.Printf("cleaning up after %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+	}()
+	defer func() {
+		if p := recover(); p != nil {
+			s.cfg.Logger.Printf("panic serving %s %s from %s (request id %q): %v\n%s",
+				req.RequestLine.Method, req.RequestLine.RequestTarget, req.RemoteAddr, requestID(w), p, debug.Stack())
+			ok = false
+		}
+	}()
+
+	s.handler(w, req)
+	return true
+}
+
+// logAccess writes the access log line, roughly the common log format plus the duration and the request id.
+func (s *Server) logAccess(req *request.Request, w *response.Writer, start time.Time, note string) {
+	if s.cfg.AccessLog == nil {
+		return
+	}
+
+	id := requestID(w)
+	if id == "" {
+		id = "-"
+	}
+	line := fmt.Sprintf("%s \"%s %s HTTP/%s\" %d %d %s id=%s",
+		req.RemoteAddr, req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion,
+		w.StatusCode(), w.BytesWritten(), time.Since(start).Round(time.Microsecond), id)
+	if note != "" {
+		line += " " + note
 	}
+	s.cfg.AccessLog.Println(line)
+}
+
+// requestID is the id set by the RequestID middleware. The handler may pass a copy of the request
+// down the chain, so it is read back from the response headers rather than the request context.
+func requestID(w *response.Writer) string {
+	id, _ := w.Header().Get([]byte(request.RequestIDHeader))
+	return id
 }
 
 //  HTTP-message   = start-line CRLF
@@ -69,17 +290,132 @@ func (s *Server) listen() {
 // status-line = HTTP-version SP status-code SP [ reason-phrase ]
 // A server MUST send the space that separates the status-code from the reason-phrase even when the reason-phrase is absent (i.e., the status-line would end with the space).
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	tc := s.conns.add(conn)
+	s.setState(tc, StateNew)
 
-	req, err := request.RequestFromReader(conn)
+	hijacked := false
+	defer func() {
+		if hijacked {
+			return // the handler owns the connection now
+		}
+		conn.Close()
+		s.setState(tc, StateClosed)
+	}()
 
-	if err != nil {
+	if s.cfg.OnConnect != nil {
+		s.cfg.OnConnect(conn)
+	}
+	if s.cfg.OnDisconnect != nil {
+		defer s.cfg.OnDisconnect(conn)
+	}
+
+	// Handshake explicitly so the negotiated state is known before the handler runs.
+	// It has its own deadline, a client stalling in it must not hold the connection with a zero ReadTimeout.
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.cfg.TLSHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			s.cfg.Logger.Printf("TLS handshake error from %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
+	if s.cfg.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.ReadTimeout))
+	}
+
+	reader := &activeReader{conn: conn, onActive: func() { s.setState(tc, StateActive) }}
+	req, err := request.RequestFromReaderWithLimits(reader, request.Limits{
+		MaxHeaderBytes: s.cfg.MaxHeaderBytes,
+		MaxBodyBytes:   s.cfg.MaxBodyBytes,
+		MaxFormKeys:    s.cfg.MaxFormKeys,
+		MaxFormBytes:   s.cfg.MaxFormBytes,
+		MaxPartBytes:   s.cfg.MaxPartBytes,
+		MaxUploadBytes: s.cfg.MaxUploadBytes,
+		// Uploads are read by the handler as they come, not held in req.Body.
+		StreamMultipart: true,
+	})
+
+	// Nothing was sent at all: a health check probing the port, or a client giving up.
+	// There's no request to answer (the parser calls an empty stream a done one), just hang up.
+	if !reader.started {
 		return
 	}
 
+	if s.cfg.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	}
+
 	w := response.NewWriter(conn)
+	if err != nil {
+		s.cfg.ErrorHandler(w, err)
+		w.Flush() // best effort, the client may already be gone
+		lingeringClose(conn)
+		return
+	}
+	req.TLS = tlsState
+	req.RemoteAddr = conn.RemoteAddr().String()
+	w.SetHijackReader(req.Rest())
+	if req.RequestLine.Method == "HEAD" {
+		w.HeadResponse()
+	}
+	s.conns.countRequest(tc)
 
-	s.handler(w, req)
+	if !s.limits.acquireRequest(s.cfg.InFlightQueueTimeout, s.closed) {
+		w.Header().Set("Retry-After", retryAfterSeconds(s.cfg.RetryAfter))
+		response.Error(w, response.StatusServiceUnavailable, "server busy, try again later\n")
+		w.Flush()
+		return
+	}
+	defer s.limits.releaseRequest()
+
+	// Only trust the peer certificate if it was verified against our client CAs.
+	if tlsState != nil && len(tlsState.VerifiedChains) > 0 {
+		req.ClientIdentity = request.IdentityFromCertificate(tlsState.VerifiedChains[0][0])
+	}
+
+	start := time.Now()
+	note := ""
+	if !s.serveRequest(w, req) {
+		note = "panic"
+		// The handler panicked. Nothing was sent yet: a fresh writer answers 500.
+		// Otherwise the response is cut short and the connection closed, the client sees it as broken.
+		if w.Hijacked() || w.Committed() {
+			s.logAccess(req, w, start, note)
+			return
+		}
+		id := requestID(w)
+		w = response.NewWriter(conn)
+		if req.RequestLine.Method == "HEAD" {
+			w.HeadResponse()
+		}
+		if id != "" {
+			w.Header().Set(request.RequestIDHeader, id)
+		}
+		response.Error(w, response.StatusInternalServerError, "internal server error\n")
+	}
+
+	if w.Hijacked() {
+		hijacked = true
+		s.setState(tc, StateHijacked)
+		s.logAccess(req, w, start, "hijacked")
+		return
+	}
+
+	// The writer is buffered, nothing reaches the client until it is flushed.
+	// Close also ends a chunked or compressed body. A body shorter than its Content-Length is
+	// reported here, closing the connection right after is what tells the client it was cut.
+	if err := w.Close(); err != nil {
+		s.cfg.Logger.Printf("Error flushing response: %v\n", err)
+	}
+	s.logAccess(req, w, start, note)
+	// The handler answered before reading the whole upload (a 413, ...), the client may still be sending it.
+	if req.BodyUnread() {
+		lingeringClose(conn)
+	}
 
 	// REFACTORED THE STRUCTURE, SO NOW DECISION MAKING MOVED TO THE APPLICATION ITSELF.
 	// if err != nil {
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	response.Error(w, response.StatusOK, req.RequestLine.RequestTarget)
+}
+
+// startServer binds a free port on localhost and closes the server at the end of the test.
+func startServer(t *testing.T, cfg Config, handler Handler) *Server {
+	t.Helper()
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+	s := New(cfg, handler)
+	require.NoError(t, s.ListenAndServe())
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// roundTrip sends a raw request and reads the raw response until the server closes the connection.
+func roundTrip(t *testing.T, network, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestServerListenAndServe(t *testing.T) {
+	s := startServer(t, Config{}, echoHandler)
+
+	// Test: The chosen port can be read back
+	addr := s.Addr().(*net.TCPAddr)
+	assert.NotZero(t, addr.Port)
+
+	out := roundTrip(t, "tcp", addr.String(), "GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/hello"))
+
+	// Test: Serving twice is refused
+	require.Error(t, s.ListenAndServe())
+}
+
+func TestServerServeListener(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := New(Config{}, echoHandler)
+	require.NoError(t, s.Serve(ln))
+	defer s.Close()
+
+	out := roundTrip(t, "tcp", ln.Addr().String(), "GET /from-listener HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "/from-listener"))
+}
+
+func TestServerLimits(t *testing.T) {
+	connected, disconnected := make(chan struct{}, 3), make(chan struct{}, 3)
+	s := startServer(t, Config{
+		MaxHeaderBytes: 64,
+		MaxBodyBytes:   4,
+		OnConnect:      func(net.Conn) { connected <- struct{}{} },
+		OnDisconnect:   func(net.Conn) { disconnected <- struct{}{} },
+	}, echoHandler)
+	addr := s.Addr().String()
+
+	// Test: Headers over the limit
+	out := roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("a", 100)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+	<-connected
+	<-disconnected
+
+	// Test: Body over the limit
+	out = roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Malformed request, the parser's message isn't echoed
+	out = roundTrip(t, "tcp", addr, "get / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nBad Request\n"))
+
+	// Test: A connection closed without sending anything gets nothing back
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	silent, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, silent)
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	s := startServer(t, Config{ReadTimeout: 50 * time.Millisecond}, echoHandler)
+
+	// Test: A request that never finishes gets a 408
+	out := roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\nHost: slow")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 408 Request Timeout\r\n"))
+}
+
+// syncBuffer is a log destination shared with the connection goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServerPanicAndAccessLog(t *testing.T) {
+	var errorLog, accessLog syncBuffer
+	cfg := Config{Logger: log.New(&errorLog, "", 0), AccessLog: log.New(&accessLog, "", 0)}
+
+	s := startServer(t, cfg, func(w *response.Writer, req *request.Request) {
+		w.Header().Set(request.RequestIDHeader, "req-42")
+		switch req.RequestLine.RequestTarget {
+		case "/panic":
+			w.Header().Set("X-Half-Done", "yes")
+			panic("boom")
+		case "/panic-late":
+			w.WriteBody([]byte("partial"))
+			w.Flush()
+			panic("boom late")
+		}
+		response.Error(w, response.StatusOK, "ok")
+	})
+	addr := s.Addr().String()
+
+	// Test: A panic before anything was sent becomes a clean 500, the server keeps going
+	out := roundTrip(t, "tcp", addr, "GET /panic HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, out, "x-request-id: req-42\r\n")
+	assert.NotContains(t, out, "x-half-done")
+
+	// Test: A panic after the headers went out just cuts the response
+	out = roundTrip(t, "tcp", addr, "GET /panic-late HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "partial"))
+
+	out = roundTrip(t, "tcp", addr, "GET /fine HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Each line is logged before the connection is closed, so they are all there and in order.
+	assert.Contains(t, errorLog.String(), `panic serving GET /panic from 127.0.0.1:`)
+	assert.Contains(t, errorLog.String(), `(request id "req-42"): boom`)
+	assert.Contains(t, errorLog.String(), "goroutine") // the stack
+
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^127\.0\.0\.1:\d+ "GET /panic HTTP/1.1" 500 22 \S+ id=req-42 panic$`, lines[0])
+	assert.Regexp(t, `^127\.0\.0\.1:\d+ "GET /panic-late HTTP/1.1" 200 7 \S+ id=req-42 panic$`, lines[1])
+	assert.Regexp(t, `^127\.0\.0\.1:\d+ "GET /fine HTTP/1.1" 200 2 \S+ id=req-42$`, lines[2])
+}
+
+func TestServerShortBody(t *testing.T) {
+	var errorLog syncBuffer
+	s := startServer(t, Config{Logger: log.New(&errorLog, "", 0)}, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.WriteBody([]byte("short"))
+	})
+
+	// Test: The client isn't left waiting for the missing bytes, the connection is closed
+	out := roundTrip(t, "tcp", s.Addr().String(), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "content-length: 10\r\n\r\nshort"))
+	assert.Contains(t, errorLog.String(), "body shorter than the Content-Length sent: 5 bytes missing")
+
+	// Test: HEAD announces the length without a body
+	out = roundTrip(t, "tcp", s.Addr().String(), "HEAD / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "content-length: 10\r\n\r\n"))
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, Config{}, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		echoHandler(w, req)
+	})
+	addr := s.Addr().String()
+
+	result := make(chan string)
+	go func() { result <- roundTrip(t, "tcp", addr, "GET /slow HTTP/1.1\r\n\r\n") }()
+	<-started
+
+	// Test: Shutdown gives up when the context ends first
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// Test: No new connections, but the in-flight one still completes
+	_, err := net.Dial("tcp", addr)
+	require.Error(t, err)
+
+	close(release)
+	assert.True(t, strings.HasSuffix(<-result, "/slow"))
+	require.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestServerReusePort(t *testing.T) {
+	if reusePortControl == nil {
+		t.Skip(ErrReusePortUnsupported)
+	}
+	s := startServer(t, Config{ReusePort: 4}, echoHandler)
+	require.Len(t, s.listeners, 4)
+
+	// Test: Every listener joined the port picked by the first one
+	for _, ln := range s.listeners {
+		assert.Equal(t, s.Addr().String(), ln.Addr().String())
+	}
+
+	for i := 0; i < 20; i++ {
+		out := roundTrip(t, "tcp", s.Addr().String(), "GET /reuse HTTP/1.1\r\n\r\n")
+		assert.True(t, strings.HasSuffix(out, "/reuse"))
+	}
+}
+
+func TestServerMultipartLimits(t *testing.T) {
+	s := startServer(t, Config{MaxPartBytes: 8, MaxUploadBytes: 12}, func(w *response.Writer, req *request.Request) {
+		if err := req.ParseMultipartForm(0); err != nil {
+			response.Error(w, response.StatusBadRequest, "bad upload")
+			return
+		}
+		response.Error(w, response.StatusOK, "uploaded")
+	})
+
+	upload := func(files ...string) string {
+		body := ""
+		for i, content := range files {
+			body += "--XyZ\r\nContent-Disposition: form-data; name=\"f\"; filename=\"" + strconv.Itoa(i) + ".txt\"\r\n\r\n" + content + "\r\n"
+		}
+		body += "--XyZ--\r\n"
+		return roundTrip(t, "tcp", s.Addr().String(), "POST /upload HTTP/1.1\r\n"+
+			"Content-Type: multipart/form-data; boundary=XyZ\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+	}
+
+	// Test: The limits of the config reach the requests
+	assert.True(t, strings.HasSuffix(upload("12345678", "1234"), "uploaded"))
+	assert.True(t, strings.HasSuffix(upload("123456789"), "bad upload"))
+	assert.True(t, strings.HasSuffix(upload("12345678", "12345"), "bad upload"))
+}
+
+func TestServerStreamedUpload(t *testing.T) {
+	s := startServer(t, Config{MaxUploadBytes: 64 << 10}, func(w *response.Writer, req *request.Request) {
+		if req.Body != nil {
+			response.Error(w, response.StatusInternalServerError, "body read up front")
+			return
+		}
+		if err := req.ParseMultipartForm(1 << 10); err != nil {
+			response.Error(w, response.StatusContentTooLarge, "too large")
+			return
+		}
+		response.Error(w, response.StatusOK, strconv.FormatInt(req.MultipartForm.File["f"][0].Size, 10))
+	})
+
+	upload := func(size int) string {
+		body := "--XyZ\r\nContent-Disposition: form-data; name=\"f\"; filename=\"f.bin\"\r\n\r\n" +
+			strings.Repeat("x", size) + "\r\n--XyZ--\r\n"
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// Written aside, the server may answer before reading all of it.
+		go conn.Write([]byte("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=XyZ\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	// Test: The handler reads the upload from the connection
+	out := upload(32 << 10)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n32768"))
+
+	// Test: Answered before the end of the upload, the client still gets the response
+	out = upload(128 << 10)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large\r\n"))
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often GetCertificate is allowed to stat the files on disk looking for a renewed certificate.
+const certReloadInterval = 10 * time.Second
+
+// CertStore holds the server certificates, picks one per connection based on SNI
+// and reloads them from disk when the files change (no restart needed after a renewal).
+type CertStore struct {
+	mu        sync.RWMutex
+	entries   []*certEntry
+	byName    map[string]*certEntry // lower-cased DNS names (and "*.example.com" wildcards)
+	lastCheck time.Time
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	cert     *tls.Certificate
+}
+
+var ErrNoCertificates = fmt.Errorf("tls: no certificates loaded")
+
+func NewCertStore() *CertStore {
+	return &CertStore{byName: make(map[string]*certEntry)}
+}
+
+// Add loads a certificate/key pair. The first one added is the default when SNI doesn't match anything.
+func (cs *CertStore) Add(certFile, keyFile string) error {
+	e := &certEntry{certFile: certFile, keyFile: keyFile}
+	if err := e.load(); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.entries = append(cs.entries, e)
+	cs.index()
+	return nil
+}
+
+// Reload re-reads every certificate whose files changed on disk.
+// A broken file keeps the old certificate in place, so a half-written renewal doesn't take the server down.
+func (cs *CertStore) Reload() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.reloadLocked()
+}
+
+func (cs *CertStore) reloadLocked() error {
+	cs.lastCheck = time.Now()
+
+	var firstErr error
+	changed := false
+	for _, e := range cs.entries {
+		modTime, err := e.latestModTime()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !modTime.After(e.modTime) {
+			continue
+		}
+		if err := e.load(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		changed = true
+	}
+
+	if changed {
+		cs.index()
+	}
+	return firstErr
+}
+
+// GetCertificate is plugged into tls.Config, it is called on every handshake.
+func (cs *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	stale := time.Since(cs.lastCheck) > certReloadInterval
+	cs.mu.RUnlock()
+
+	if stale {
+		cs.mu.Lock()
+		// someone else may have reloaded while we waited for the lock
+		if time.Since(cs.lastCheck) > certReloadInterval {
+			cs.reloadLocked()
+		}
+		cs.mu.Unlock()
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if len(cs.entries) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if e, ok := cs.byName[name]; ok {
+		return e.cert, nil
+	}
+
+	// www.example.com -> *.example.com
+	if _, rest, found := strings.Cut(name, "."); found {
+		if e, ok := cs.byName["*."+rest]; ok {
+			return e.cert, nil
+		}
+	}
+
+	return cs.entries[0].cert, nil
+}
+
+// TLSConfig returns a tls.Config using this store for certificate selection.
+func (cs *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cs.GetCertificate,
+	}
+}
+
+// LoadClientCAs reads a PEM bundle of the CAs allowed to sign client certificates.
+func LoadClientCAs(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// WithClientAuth enables mutual TLS on the config. With required=false, clients without a
+// certificate are still accepted (and have no ClientIdentity), but a presented certificate must verify.
+func WithClientAuth(cfg *tls.Config, caFile string, required bool) error {
+	pool, err := LoadClientCAs(caFile)
+	if err != nil {
+		return err
+	}
+
+	cfg.ClientCAs = pool
+	if required {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
+
+// index rebuilds the name -> certificate map, caller must hold the lock.
+func (cs *CertStore) index() {
+	cs.byName = make(map[string]*certEntry)
+	for _, e := range cs.entries {
+		leaf := e.cert.Leaf
+		if leaf == nil {
+			continue
+		}
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, n := range names {
+			n = strings.ToLower(n)
+			// first one wins, same order they were added
+			if _, exists := cs.byName[n]; !exists {
+				cs.byName[n] = e
+			}
+		}
+	}
+}
+
+func (e *certEntry) load() error {
+	modTime, err := e.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+
+	e.cert = &cert
+	e.modTime = modTime
+	return nil
+}
+
+// The newest of the two files, renewals usually rewrite both.
+func (e *certEntry) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(e.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(e.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/certgen"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a fresh self-signed certificate for hosts, with a random serial number.
+func writeCert(t *testing.T, certFile, keyFile string, hosts ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestCertStore(t *testing.T) {
+	dir := t.TempDir()
+	apiCert, apiKey := filepath.Join(dir, "api.pem"), filepath.Join(dir, "api-key.pem")
+	wildCert, wildKey := filepath.Join(dir, "wild.pem"), filepath.Join(dir, "wild-key.pem")
+	writeCert(t, apiCert, apiKey, "api.example.com")
+	writeCert(t, wildCert, wildKey, "*.example.org")
+
+	cs := NewCertStore()
+	require.NoError(t, cs.Add(apiCert, apiKey))
+	require.NoError(t, cs.Add(wildCert, wildKey))
+
+	get := func(sni string) *tls.Certificate {
+		cert, err := cs.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+		require.NoError(t, err)
+		return cert
+	}
+
+	// Test: SNI selection, wildcard and default
+	assert.Equal(t, []string{"api.example.com"}, get("API.example.com").Leaf.DNSNames)
+	assert.Equal(t, []string{"*.example.org"}, get("www.example.org").Leaf.DNSNames)
+	assert.Equal(t, []string{"api.example.com"}, get("unknown.test").Leaf.DNSNames)
+
+	// Test: Renewed files are picked up on Reload
+	before := get("api.example.com").Leaf.SerialNumber
+	writeCert(t, apiCert, apiKey, "api.example.com")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(apiCert, future, future))
+	require.NoError(t, cs.Reload())
+	assert.NotEqual(t, before, get("api.example.com").Leaf.SerialNumber)
+
+	// Test: Empty store
+	_, err := NewCertStore().GetCertificate(&tls.ClientHelloInfo{})
+	require.ErrorIs(t, err, ErrNoCertificates)
+}
+
+// tlsRoundTrip sends a raw request over TLS, with the client certificate when there is one.
+func tlsRoundTrip(addr string, clientCert *tls.Certificate, raw string) (string, error) {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		// Sent even when its CA isn't one the server asks for, Certificates would skip it.
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert, nil
+		}
+	}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(conn)
+	return string(out), err
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	writeCert(t, serverCert, serverKey, "localhost")
+
+	ca, err := certgen.NewCA("clients CA", time.Hour)
+	require.NoError(t, err)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}), 0o644))
+
+	issue := func(ca *certgen.CA, hosts ...string) *tls.Certificate {
+		certPEM, keyPEM, err := ca.Issue(hosts, time.Hour)
+		require.NoError(t, err)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		return &cert
+	}
+	client := issue(ca, "billing.internal", "spiffe://mesh/ns/ops/sa/billing")
+	otherCA, err := certgen.NewCA("someone else", time.Hour)
+	require.NoError(t, err)
+	stranger := issue(otherCA, "billing.internal")
+
+	start := func(required bool) string {
+		cs := NewCertStore()
+		require.NoError(t, cs.Add(serverCert, serverKey))
+		tlsCfg := cs.TLSConfig()
+		require.NoError(t, WithClientAuth(tlsCfg, caFile, required))
+		s := startServer(t, Config{TLSConfig: tlsCfg}, func(w *response.Writer, req *request.Request) {
+			id := req.ClientIdentity
+			if id == nil {
+				response.Error(w, response.StatusOK, "anonymous")
+				return
+			}
+			response.Error(w, response.StatusOK, id.CommonName+" "+strings.Join(id.DNSNames, ",")+" "+strings.Join(id.SPIFFEIDs, ","))
+		})
+		return s.Addr().String()
+	}
+
+	// Test: The verified certificate becomes the ClientIdentity
+	addr := start(true)
+	out, err := tlsRoundTrip(addr, client, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbilling.internal billing.internal spiffe://mesh/ns/ops/sa/billing"))
+
+	// Test: Required, no certificate or one from another CA never reaches the handler
+	for _, cert := range []*tls.Certificate{nil, stranger} {
+		out, _ := tlsRoundTrip(addr, cert, "GET / HTTP/1.1\r\n\r\n")
+		assert.NotContains(t, out, "HTTP/1.1 200 OK")
+	}
+
+	// Test: Optional, anonymous clients get in without identity, a bad certificate still doesn't
+	addr = start(false)
+	out, err = tlsRoundTrip(addr, nil, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nanonymous"))
+	out, _ = tlsRoundTrip(addr, stranger, "GET / HTTP/1.1\r\n\r\n")
+	assert.NotContains(t, out, "HTTP/1.1 200 OK")
+
+	// Test: A CA file without certificates
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("nothing here"), 0o644))
+	require.Error(t, WithClientAuth(&tls.Config{}, empty, true))
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	writeCert(t, certFile, keyFile, "localhost")
+	cs := NewCertStore()
+	require.NoError(t, cs.Add(certFile, keyFile))
+
+	// Test: The zero Config still bounds the handshake
+	assert.Equal(t, DefaultTLSHandshakeTimeout, Config{}.withDefaults().TLSHandshakeTimeout)
+
+	// Test: A client that never starts the handshake is dropped, with no ReadTimeout set
+	var errorLog syncBuffer
+	s := startServer(t, Config{
+		TLSConfig:           cs.TLSConfig(),
+		TLSHandshakeTimeout: 50 * time.Millisecond,
+		Logger:              log.New(&errorLog, "", 0),
+	}, echoHandler)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, errorLog.String(), "TLS handshake error")
+
+	// Test: A client that does the handshake is served as usual
+	out, err := tlsRoundTrip(s.Addr().String(), nil, "GET /after HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "/after"))
+}
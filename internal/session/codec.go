@@ -0,0 +1,125 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCookie = errors.New("session cookie invalid or tampered with")
+	ErrShortKey      = errors.New("session keys need at least 32 bytes")
+	ErrNoKeys        = errors.New("session needs at least one key")
+)
+
+// Codec signs (or encrypts) cookie values. The first key makes new values, all of them are
+// tried on the way back in: add the new key in front, drop the old one once the old cookies expired.
+type Codec struct {
+	encrypt bool
+	keys    []codecKey
+}
+
+type codecKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// NewCodec derives a signing key and an AES-256 key from each secret, so one secret per
+// rotation step is enough. With encrypt the value is AES-GCM sealed (GCM authenticates it,
+// no HMAC needed on top), otherwise it stays readable and is HMAC-SHA256 signed.
+func NewCodec(encrypt bool, secrets ...[]byte) (*Codec, error) {
+	if len(secrets) == 0 {
+		return nil, ErrNoKeys
+	}
+	c := &Codec{encrypt: encrypt}
+	for _, secret := range secrets {
+		if len(secret) < 32 {
+			return nil, ErrShortKey
+		}
+		block, err := aes.NewCipher(derive(secret, "session encryption"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = append(c.keys, codecKey{mac: derive(secret, "session signing"), aead: aead})
+	}
+	return c, nil
+}
+
+func derive(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Encode protects value for the cookie called name, the name is bound in so a value can't be
+// moved to another cookie.
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	key := c.keys[0]
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := key.aead.Seal(nonce, nonce, value, []byte(name))
+		return base64.RawURLEncoding.EncodeToString(sealed), nil
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(value)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key.mac, name, payload)), nil
+}
+
+// Decode checks and opens a value made by Encode with any of the keys.
+func (c *Codec) Decode(name, encoded string) ([]byte, error) {
+	if c.encrypt {
+		sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalidCookie
+		}
+		for _, key := range c.keys {
+			n := key.aead.NonceSize()
+			if len(sealed) < n {
+				return nil, ErrInvalidCookie
+			}
+			if value, err := key.aead.Open(nil, sealed[:n], sealed[n:], []byte(name)); err == nil {
+				return value, nil
+			}
+		}
+		return nil, ErrInvalidCookie
+	}
+
+	payload, sig, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return nil, ErrInvalidCookie
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	for _, key := range c.keys {
+		if hmac.Equal(mac, sign(key.mac, name, payload)) {
+			value, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, ErrInvalidCookie
+			}
+			return value, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+func sign(key []byte, name, payload string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(name))
+	m.Write([]byte{0})
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
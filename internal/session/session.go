@@ -0,0 +1,307 @@
+// Package session keeps per user state across requests, in a signed (or encrypted) cookie or
+// in a server side Store with the cookie holding only the id.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"boot.mossad.http/internal/cookie"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// Browsers only promise 4096 bytes per cookie, name and attributes included.
+const maxCookieSize = 4096
+
+// 16 random bytes per session id.
+const idLen = 16
+
+var ErrCookieTooLarge = errors.New("session too large for a cookie, use a Store")
+
+// Config of the session manager.
+type Config struct {
+	// Keys sign (and encrypt) the cookie, newest first, at least 32 random bytes each.
+	// Rotate by putting a new key in front, the older ones are still accepted.
+	Keys [][]byte
+	// Encrypt hides the values from the client with AES-GCM, they are only signed otherwise.
+	// Ignored with a Store, the cookie holds nothing but the id then.
+	Encrypt bool
+	// Store keeps the sessions on the server, nil keeps the whole session in the cookie.
+	Store Store
+
+	// IdleTimeout ends a session unused for that long, 30 minutes when zero.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session that long after it was created whatever happens, 24 hours when zero.
+	AbsoluteTimeout time.Duration
+
+	// Cookie attributes. CookieName is "session" and Path "/" when empty, the cookie is always HttpOnly
+	// and SameSite is Lax unless set. Set Secure when served over TLS.
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   cookie.SameSite
+
+	// Logger for store errors, log.Default() when nil.
+	Logger *log.Logger
+}
+
+// Manager loads the session of each request and saves it once the handler is done.
+type Manager struct {
+	cfg   Config
+	codec *Codec
+	now   func() time.Time
+}
+
+func NewManager(cfg Config) (*Manager, error) {
+	codec, err := NewCodec(cfg.Encrypt && cfg.Store == nil, cfg.Keys...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Minute
+	}
+	if cfg.AbsoluteTimeout <= 0 {
+		cfg.AbsoluteTimeout = 24 * time.Hour
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.SameSite == cookie.SameSiteDefault {
+		cfg.SameSite = cookie.SameSiteLax
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	return &Manager{cfg: cfg, codec: codec, now: time.Now}, nil
+}
+
+// Session is the state of one client. It belongs to the request handling it, don't keep it around.
+type Session struct {
+	id  string // store id, empty until saved (or always, in cookie mode)
+	rec Record
+
+	isNew     bool // no valid session came with the request
+	modified  bool
+	destroyed bool
+	oldID     string // to delete from the store after RenewID
+}
+
+func (s *Session) Get(key string) string {
+	return s.rec.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	if s.rec.Values == nil {
+		s.rec.Values = map[string]string{}
+	}
+	s.rec.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.modified = true
+	}
+}
+
+// IsNew is true when the request came without a valid, unexpired session.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Created is when the session started (or was last renewed).
+func (s *Session) Created() time.Time {
+	return s.rec.Created
+}
+
+// RenewID gives the session a new id and a new start, keeping the values. Call it on login and on
+// any privilege change: an id planted or seen before the login is worth nothing after it.
+func (s *Session) RenewID() {
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = ""
+	s.rec.Created = time.Time{}
+	s.modified = true
+}
+
+// Destroy ends the session (logout): the values are dropped, the cookie deleted.
+func (s *Session) Destroy() {
+	s.rec.Values = nil
+	s.destroyed = true
+	s.modified = true
+}
+
+type sessionKey struct{}
+
+// FromContext is the session of the request, nil outside the middleware.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Get is FromContext(req.Context()).
+func Get(req *request.Request) *Session {
+	return FromContext(req.Context())
+}
+
+// Middleware loads the session before the handler and writes it back (store and cookie) right
+// before the response is committed, so handlers just use Get(req) and never think about saving.
+func (m *Manager) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			s := m.load(req)
+			w.OnCommit(func() {
+				if err := m.save(w, s); err != nil {
+					m.cfg.Logger.Printf("saving session for %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+				}
+			})
+			next(w, req.WithContext(context.WithValue(req.Context(), sessionKey{}, s)))
+		}
+	}
+}
+
+// load never fails: a missing, forged or expired session is a new empty one.
+func (m *Manager) load(req *request.Request) *Session {
+	now := m.now()
+	fresh := &Session{isNew: true, rec: Record{Created: now, LastSeen: now}}
+
+	c, err := req.Cookie(m.cfg.CookieName)
+	if err != nil {
+		return fresh
+	}
+	value, err := m.codec.Decode(m.cfg.CookieName, c.Value)
+	if err != nil {
+		return fresh
+	}
+
+	s := &Session{}
+	if m.cfg.Store != nil {
+		s.id = string(value)
+		rec, err := m.cfg.Store.Load(s.id)
+		if err != nil {
+			if err != ErrNotFound {
+				m.cfg.Logger.Printf("loading session: %v", err)
+			}
+			return fresh
+		}
+		s.rec = *rec
+	} else if err := json.Unmarshal(value, &s.rec); err != nil {
+		return fresh
+	}
+
+	if now.Sub(s.rec.LastSeen) > m.cfg.IdleTimeout || now.Sub(s.rec.Created) > m.cfg.AbsoluteTimeout {
+		if s.id != "" {
+			m.cfg.Store.Delete(s.id)
+		}
+		return fresh
+	}
+	return s
+}
+
+// save runs in the OnCommit hook. Empty new sessions are never stored, no cookie for every visitor.
+func (m *Manager) save(w *response.Writer, s *Session) error {
+	if s.destroyed {
+		var err error
+		if m.cfg.Store != nil {
+			err = errors.Join(m.deleteID(s.id), m.deleteID(s.oldID))
+		}
+		if !s.isNew {
+			err = errors.Join(err, w.SetCookie(m.cookie("", -1)))
+		}
+		return err
+	}
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	now := m.now()
+	// Only touch an untouched session once a minute, not a store write (or a cookie) per request.
+	if !s.modified && now.Sub(s.rec.LastSeen) < time.Minute {
+		return nil
+	}
+	if s.rec.Created.IsZero() {
+		s.rec.Created = now
+	}
+	s.rec.LastSeen = now
+
+	// What is left of the session, the cookie doesn't have to outlive it.
+	ttl := min(m.cfg.IdleTimeout, s.rec.Created.Add(m.cfg.AbsoluteTimeout).Sub(now))
+	if ttl <= 0 {
+		return nil
+	}
+
+	var value []byte
+	if m.cfg.Store != nil {
+		if s.id == "" {
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			s.id = id
+		}
+		if err := m.cfg.Store.Save(s.id, &s.rec, ttl); err != nil {
+			return err
+		}
+		if err := m.deleteID(s.oldID); err != nil {
+			return err
+		}
+		value = []byte(s.id)
+	} else {
+		data, err := json.Marshal(&s.rec)
+		if err != nil {
+			return err
+		}
+		value = data
+	}
+
+	encoded, err := m.codec.Encode(m.cfg.CookieName, value)
+	if err != nil {
+		return err
+	}
+	c := m.cookie(encoded, max(int(ttl/time.Second), 1))
+	if len(c.String()) > maxCookieSize {
+		return ErrCookieTooLarge
+	}
+	return w.SetCookie(c)
+}
+
+func (m *Manager) deleteID(id string) error {
+	if id == "" {
+		return nil
+	}
+	return m.cfg.Store.Delete(id)
+}
+
+func (m *Manager) cookie(value string, maxAge int) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.cfg.CookieName,
+		Value:    value,
+		Path:     m.cfg.Path,
+		Domain:   m.cfg.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.cfg.Secure,
+		HttpOnly: true,
+		SameSite: m.cfg.SameSite,
+	}
+}
+
+func newID() (string, error) {
+	var b [idLen]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
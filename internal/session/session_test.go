@@ -0,0 +1,209 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"boot.mossad.http/internal/cookie"
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key1 = []byte("0123456789abcdef0123456789abcdef")
+	key2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// serve runs one request with the given session cookie (empty for none), returns the
+// response and the new cookie value ("" when none was set, "-" when it was deleted).
+func serve(t *testing.T, m *Manager, handler func(w *response.Writer, req *request.Request), sessionCookie string) (string, string) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\n"
+	if sessionCookie != "" {
+		raw += "Cookie: theme=dark; session=" + sessionCookie + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	m.Middleware()(handler)(w, req)
+	require.NoError(t, w.Close())
+
+	for _, line := range strings.Split(out.String(), "\r\n") {
+		if value, ok := strings.CutPrefix(line, "set-cookie: "); ok {
+			c, err := cookie.ParseSetCookie(value)
+			require.NoError(t, err)
+			assert.True(t, c.HttpOnly)
+			if c.MaxAge < 0 {
+				return out.String(), "-"
+			}
+			return out.String(), c.Value
+		}
+	}
+	return out.String(), ""
+}
+
+// tamper changes the first character of the value.
+func tamper(value string) string {
+	if value[0] == 'A' {
+		return "B" + value[1:]
+	}
+	return "A" + value[1:]
+}
+
+func login(w *response.Writer, req *request.Request) {
+	s := Get(req)
+	s.RenewID()
+	s.Set("user", "ada")
+	w.Write([]byte("welcome"))
+}
+
+func whoami(w *response.Writer, req *request.Request) {
+	w.Write([]byte("user=" + Get(req).Get("user")))
+}
+
+func logout(w *response.Writer, req *request.Request) {
+	Get(req).Destroy()
+}
+
+func TestCodec(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		old, err := NewCodec(encrypt, key1)
+		require.NoError(t, err)
+		encoded, err := old.Encode("session", []byte(`{"user":"ada"}`))
+		require.NoError(t, err)
+
+		// Test: Readable only when signed
+		assert.Equal(t, !encrypt, strings.Contains(encoded, "eyJ1c2VyIjoiYWRhIn0"), "encrypt=%v", encrypt)
+
+		// Test: Rotation, the old key still opens old values
+		rotated, err := NewCodec(encrypt, key2, key1)
+		require.NoError(t, err)
+		value, err := rotated.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, `{"user":"ada"}`, string(value))
+
+		// Test: Tampering, another cookie name, a dropped key
+		_, err = rotated.Decode("session", tamper(encoded))
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		_, err = rotated.Decode("other", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		newOnly, err := NewCodec(encrypt, key2)
+		require.NoError(t, err)
+		_, err = newOnly.Decode("session", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+	}
+
+	_, err := NewCodec(false, []byte("short"))
+	assert.ErrorIs(t, err, ErrShortKey)
+	_, err = NewCodec(false)
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestCookieSessions(t *testing.T) {
+	m, err := NewManager(Config{Keys: [][]byte{key1}, Encrypt: true})
+	require.NoError(t, err)
+
+	// Test: Visitors without a session get no cookie
+	_, set := serve(t, m, whoami, "")
+	assert.Equal(t, "", set)
+
+	// Test: Login, then the session comes back
+	_, sid := serve(t, m, login, "")
+	require.NotEmpty(t, sid)
+	out, set := serve(t, m, whoami, sid)
+	assert.True(t, strings.HasSuffix(out, "user=ada"))
+	assert.Equal(t, "", set) // nothing changed, no new cookie
+
+	// Test: A forged cookie is a new session
+	out, _ = serve(t, m, whoami, tamper(sid))
+	assert.True(t, strings.HasSuffix(out, "user="))
+
+	// Test: Logout deletes the cookie
+	_, set = serve(t, m, logout, sid)
+	assert.Equal(t, "-", set)
+}
+
+func TestStoreSessions(t *testing.T) {
+	store := NewMemoryStore()
+	m, err := NewManager(Config{Keys: [][]byte{key1}, Store: store, IdleTimeout: time.Hour, AbsoluteTimeout: 3 * time.Hour})
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	store.now = m.now
+
+	// Test: The cookie holds only the signed id
+	_, first := serve(t, m, login, "")
+	require.NotEmpty(t, first)
+	assert.Equal(t, 1, store.Len())
+	out, _ := serve(t, m, whoami, first)
+	assert.True(t, strings.HasSuffix(out, "user=ada"))
+
+	// Test: Logging in again renews the id, the old one is gone from the store
+	_, second := serve(t, m, login, first)
+	require.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, 1, store.Len())
+	out, _ = serve(t, m, whoami, first)
+	assert.True(t, strings.HasSuffix(out, "user="))
+
+	// Test: Idle timeout, touched sessions live on
+	now = now.Add(50 * time.Minute)
+	out, touched := serve(t, m, whoami, second)
+	assert.True(t, strings.HasSuffix(out, "user=ada"))
+	assert.Equal(t, second, touched) // same id, longer Max-Age
+	now = now.Add(50 * time.Minute)
+	out, _ = serve(t, m, whoami, second)
+	assert.True(t, strings.HasSuffix(out, "user=ada"))
+
+	// Test: Absolute timeout, however active the user is
+	for i := 0; i < 4; i++ {
+		now = now.Add(50 * time.Minute)
+		out, _ = serve(t, m, whoami, second)
+	}
+	assert.True(t, strings.HasSuffix(out, "user="))
+
+	// Test: Idle for too long
+	_, third := serve(t, m, login, "")
+	now = now.Add(61 * time.Minute)
+	out, _ = serve(t, m, whoami, third)
+	assert.True(t, strings.HasSuffix(out, "user="))
+
+	// Test: Logout removes it from the store
+	_, fourth := serve(t, m, login, "")
+	_, set := serve(t, m, logout, fourth)
+	assert.Equal(t, "-", set)
+	out, _ = serve(t, m, whoami, fourth)
+	assert.True(t, strings.HasSuffix(out, "user="))
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	id, err := newID()
+	require.NoError(t, err)
+	rec := &Record{Values: map[string]string{"user": "ada"}, Created: now, LastSeen: now}
+	require.NoError(t, store.Save(id, rec, time.Minute))
+
+	loaded, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "ada", loaded.Values["user"])
+
+	// Test: Ids that aren't ours never reach the file system
+	_, err = store.Load("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Test: Expiry
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, store.Sweep())
+	_, err = store.Load(id)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,208 @@
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Record is what a session is made of, in the cookie or in a store.
+type Record struct {
+	Values   map[string]string `json:"v"`
+	Created  time.Time         `json:"c"` // for the absolute timeout
+	LastSeen time.Time         `json:"s"` // for the idle timeout
+}
+
+// Store keeps sessions on the server, the cookie only holds the (signed) id.
+// Implementations are used by every request at once.
+type Store interface {
+	// Load returns ErrNotFound for unknown and expired ids.
+	Load(id string) (*Record, error)
+	// Save keeps the record for ttl, after that it may be dropped.
+	Save(id string, rec *Record, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// MemoryStore keeps the sessions in a map, they are lost on restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	rec     Record
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]memoryEntry{}, now: time.Now}
+}
+
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok || s.now().After(e.expires) {
+		delete(s.sessions, id)
+		return nil, ErrNotFound
+	}
+	rec := e.rec
+	rec.Values = copyValues(e.rec.Values) // the caller may modify it
+	return &rec, nil
+}
+
+func (s *MemoryStore) Save(id string, rec *Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	saved := *rec
+	saved.Values = copyValues(rec.Values)
+	s.sessions[id] = memoryEntry{rec: saved, expires: now.Add(ttl)}
+
+	// Abandoned sessions are never loaded again, sweep them once a minute.
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = now
+		for id, e := range s.sessions {
+			if now.After(e.expires) {
+				delete(s.sessions, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Len is the number of sessions held, expired ones not swept yet included.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func copyValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
+
+// FileStore keeps one JSON file per session in a directory, so sessions survive a restart
+// (or a hot upgrade). Expired files are removed when loaded, or by Sweep.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+type fileEntry struct {
+	Record
+	Expires time.Time `json:"e"`
+}
+
+// NewFileStore uses dir, created when missing. Only the server should be able to read it.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// The ids are ours (hex), anything else never touches the file system.
+func (s *FileStore) path(id string) (string, bool) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != idLen*2 {
+		return "", false
+	}
+	return filepath.Join(s.dir, id+".json"), true
+}
+
+func (s *FileStore) Load(id string) (*Record, error) {
+	path, ok := s.path(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var e fileEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	if s.now().After(e.Expires) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return &e.Record, nil
+}
+
+func (s *FileStore) Save(id string, rec *Record, ttl time.Duration) error {
+	path, ok := s.path(id)
+	if !ok {
+		return ErrNotFound
+	}
+	data, err := json.Marshal(fileEntry{Record: *rec, Expires: s.now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, a concurrent Load never sees half a file.
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, ok := s.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Sweep removes the expired session files, run it now and then (a ticker is fine).
+func (s *FileStore) Sweep() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		s.Load(name[:len(name)-len(".json")]) // drops it when expired
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Span is one finished unit of work, a request handled by this server.
+type Span struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID // zero when the trace started here
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Status       int
+	Attributes   map[string]string
+}
+
+// Exporter ships finished spans to wherever the tracing backend reads them from.
+// Export is called from the request goroutines, so it must be safe for concurrent use.
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// ExporterFunc lets a plain function be an Exporter.
+type ExporterFunc func(span *Span) error
+
+func (f ExporterFunc) Export(span *Span) error { return f(span) }
+
+// spanRecord is the JSON shape of a span, ids in hex and times in RFC 3339 with nanoseconds.
+type spanRecord struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMS   float64           `json:"duration_ms"`
+	Status       int               `json:"status"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// JSONLinesExporter writes one JSON object per span and per line, the format most log shippers pick up.
+type JSONLinesExporter struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewJSONLinesExporter writes to w, every span is flushed right away.
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	e := &JSONLinesExporter{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		e.closer = c
+	}
+	return e
+}
+
+// NewFileExporter appends the spans to the file, creating it if needed.
+func NewFileExporter(path string) (*JSONLinesExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesExporter(f), nil
+}
+
+func (e *JSONLinesExporter) Export(span *Span) error {
+	rec := spanRecord{
+		TraceID:    span.TraceID.String(),
+		SpanID:     span.SpanID.String(),
+		Name:       span.Name,
+		Start:      span.Start,
+		End:        span.End,
+		DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+		Status:     span.Status,
+		Attributes: span.Attributes,
+	}
+	if span.ParentSpanID.IsValid() {
+		rec.ParentSpanID = span.ParentSpanID.String()
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(line); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Close closes the underlying writer when it is closable (the file of NewFileExporter).
+func (e *JSONLinesExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
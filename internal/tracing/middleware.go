@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"boot.mossad.http/internal/server"
+)
+
+// Config of the tracing middleware.
+type Config struct {
+	// Exporter receives a span per request, spans are dropped when nil (ids are still propagated).
+	Exporter Exporter
+	// SpanName names the span, "METHOD /path" by default.
+	SpanName func(req *request.Request) string
+	// Logger for export errors, log.Default() when nil.
+	Logger *log.Logger
+}
+
+// Middleware continues the caller's trace (or starts a new one), puts the span context in the
+// request context, echoes traceparent/tracestate on the response and exports a span once the handler returns.
+func Middleware(cfg Config) server.Middleware {
+	if cfg.SpanName == nil {
+		cfg.SpanName = defaultSpanName
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+
+			parent, hasParent := Extract(req.Headers)
+			sc := SpanContext{SpanID: NewSpanID()}
+			if hasParent {
+				sc.TraceID = parent.TraceID
+				sc.Flags = parent.Flags
+				sc.TraceState = parent.TraceState
+			} else {
+				sc.TraceID = NewTraceID()
+				sc.Flags = flagSampled
+			}
+
+			// Set before next runs, the headers have to be staged before the body commits them.
+			Inject(w.Header(), sc)
+
+			next(w, req.WithContext(ContextWithSpan(req.Context(), sc)))
+
+			if cfg.Exporter == nil || !sc.Sampled() {
+				return
+			}
+
+			span := &Span{
+				TraceID:    sc.TraceID,
+				SpanID:     sc.SpanID,
+				Name:       cfg.SpanName(req),
+				Start:      start,
+				End:        time.Now(),
+				Status:     int(w.StatusCode()),
+				Attributes: attributes(w, req),
+			}
+			if hasParent {
+				span.ParentSpanID = parent.SpanID
+			}
+			if err := cfg.Exporter.Export(span); err != nil {
+				cfg.Logger.Printf("tracing: exporting span %s: %v", span.SpanID, err)
+			}
+		}
+	}
+}
+
+func defaultSpanName(req *request.Request) string {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return req.RequestLine.Method + " " + path
+}
+
+func attributes(w *response.Writer, req *request.Request) map[string]string {
+	attrs := map[string]string{
+		"http.method":             req.RequestLine.Method,
+		"http.target":             req.RequestLine.RequestTarget,
+		"http.status_code":        strconv.Itoa(int(w.StatusCode())),
+		"http.request_body_size":  strconv.FormatInt(req.BodyBytes(), 10),
+		"http.response_body_size": strconv.Itoa(w.BytesWritten()),
+		"net.peer.address":        req.RemoteAddr,
+	}
+	if host, err := req.Headers.Get([]byte("Host")); err == nil {
+		attrs["http.host"] = host
+	}
+	if req.TLS != nil {
+		attrs["http.scheme"] = "https"
+	} else {
+		attrs["http.scheme"] = "http"
+	}
+	return attrs
+}
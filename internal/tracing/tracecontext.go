@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"boot.mossad.http/internal/headers"
+)
+
+// The W3C Trace Context headers (https://www.w3.org/TR/trace-context/).
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+const flagSampled = 0x01
+
+var ErrInvalidTraceparent = fmt.Errorf("invalid traceparent")
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext is what travels between services: the trace, the current span and the vendor state.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string // tracestate, passed along untouched
+}
+
+func (sc SpanContext) Sampled() bool { return sc.Flags&flagSampled != 0 }
+
+// Traceparent renders the version 00 header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent reads a traceparent header value.
+// Versions above 00 are accepted as long as they start with the 00 fields, like the spec asks.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+
+	// version-traceid-spanid-flags: 2+1+32+1+16+1+2
+	if len(value) < 55 {
+		return sc, ErrInvalidTraceparent
+	}
+	version, err := decodeHex(value[0:2])
+	if err != nil || version[0] == 0xff || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(value) != 55 {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+
+	traceID, err := decodeHex(value[3:35])
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := decodeHex(value[36:52])
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := decodeHex(value[53:55])
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+
+	// all zeros means "no trace", the header is ignored
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// Only lowercase hex is allowed by the spec.
+func decodeHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, ErrInvalidTraceparent
+	}
+	return hex.DecodeString(s)
+}
+
+// Extract reads the incoming trace context, false when there is none (or it is invalid).
+func Extract(h headers.Headers) (SpanContext, bool) {
+	value, err := h.Get([]byte(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	// tracestate without a valid traceparent is dropped with it
+	sc.TraceState, _ = h.Get([]byte(TracestateHeader))
+	return sc, true
+}
+
+// Inject sets the headers for an outgoing call made on behalf of sc.
+func Inject(h headers.Headers, sc SpanContext) {
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+func NewTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func NewSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+type contextKey struct{}
+
+// ContextWithSpan stores the current span context, handlers get it back with FromContext.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext is the span of the request being handled.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"boot.mossad.http/internal/request"
+	"boot.mossad.http/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	// Test: the example from the spec
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// Test: a future version with extra fields is read as 00
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-whatever")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", // 00 is exactly 55 chars
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",       // forbidden version
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",       // zero trace id
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",       // zero span id
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",       // uppercase
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(bad)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, bad)
+	}
+}
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (m *memExporter) Export(span *Span) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, span)
+	return nil
+}
+
+func run(t *testing.T, cfg Config, raw string) (string, SpanContext) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var seen SpanContext
+	h := Middleware(cfg)(func(w *response.Writer, req *request.Request) {
+		seen, _ = FromContext(req.Context())
+		response.Error(w, response.StatusBadRequest, "nope")
+	})
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	h(w, req)
+	require.NoError(t, w.Flush())
+	return out.String(), seen
+}
+
+func TestMiddleware(t *testing.T) {
+	exp := &memExporter{}
+	cfg := Config{Exporter: exp}
+
+	// Test: continues the incoming trace, with a new span id
+	out, sc := run(t, cfg, "GET /users?id=1 HTTP/1.1\r\nHost: api\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\nTracestate: vendor=abc\r\n\r\n")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.NotEqual(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.Contains(t, out, "traceparent: "+sc.Traceparent()+"\r\n")
+	assert.Contains(t, out, "tracestate: vendor=abc\r\n")
+
+	require.Len(t, exp.spans, 1)
+	span := exp.spans[0]
+	assert.Equal(t, "GET /users", span.Name)
+	assert.Equal(t, sc.SpanID, span.SpanID)
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID.String())
+	assert.Equal(t, 400, span.Status)
+	assert.Equal(t, "api", span.Attributes["http.host"])
+	assert.False(t, span.End.Before(span.Start))
+
+	// Test: no (or an invalid) header starts a new sampled trace
+	out, sc = run(t, cfg, "GET / HTTP/1.1\r\nTraceparent: garbage\r\nTracestate: vendor=abc\r\n\r\n")
+	assert.True(t, sc.TraceID.IsValid())
+	assert.True(t, sc.Sampled())
+	assert.Empty(t, sc.TraceState)
+	assert.NotContains(t, out, "tracestate")
+	require.Len(t, exp.spans, 2)
+	assert.False(t, exp.spans[1].ParentSpanID.IsValid())
+
+	// Test: unsampled traces are propagated but not exported
+	out, _ = run(t, cfg, "GET / HTTP/1.1\r\nTraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\n\r\n")
+	assert.Contains(t, out, "traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-")
+	assert.Len(t, exp.spans, 2)
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exp, err := NewFileExporter(path)
+	require.NoError(t, err)
+
+	run(t, Config{Exporter: exp}, "GET /a HTTP/1.1\r\n\r\n")
+	run(t, Config{Exporter: exp}, "POST /b HTTP/1.1\r\nTraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	require.NoError(t, exp.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+
+	require.Len(t, records, 2)
+	assert.Equal(t, "GET /a", records[0]["name"])
+	assert.NotContains(t, records[0], "parent_span_id")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[1]["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", records[1]["parent_span_id"])
+	assert.Equal(t, float64(400), records[1]["status"])
+}